@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dcnetio/dc/config"
@@ -71,9 +72,12 @@ type EnclaveIdInfo struct {
 
 var gChainApi *gsrpc.SubstrateAPI
 var gMeta *types.Metadata
+var gChainApiLock sync.Mutex
 
 // Get connected to the blockchain
 func GetChainApi() (chainApi *gsrpc.SubstrateAPI, meta *types.Metadata, err error) {
+	gChainApiLock.Lock()
+	defer gChainApiLock.Unlock()
 	if gChainApi != nil && gMeta != nil {
 		chainApi = gChainApi
 		meta = gMeta
@@ -82,12 +86,13 @@ func GetChainApi() (chainApi *gsrpc.SubstrateAPI, meta *types.Metadata, err erro
 	//Connect to the blockchain
 	chainApi, err = gsrpc.NewSubstrateAPI(config.RunningConfig.ChainWsUrl)
 	if err != nil {
-		log.Errorf("Cann't connect to blockchain,please check chainWsUrl in /opt/dcnetio/etc/manage_config.yaml is correct.err: %v", err)
+		log.Errorf("Cann't connect to blockchain,please check chainWsUrl in %s is correct.err: %v", config.Config_file_path, err)
 		return
 	}
 	meta, err = chainApi.RPC.State.GetMetadataLatest()
 	if err != nil {
 		log.Errorf("Cann't get meta from blockchain,err: %v", err)
+		chainApi.Client.Close()
 		return
 	}
 	gChainApi = chainApi
@@ -95,8 +100,13 @@ func GetChainApi() (chainApi *gsrpc.SubstrateAPI, meta *types.Metadata, err erro
 	return
 }
 
-// Reset connection to blockchain
+// Reset connection to blockchain,the websocket of the old connection is closed
 func ResetChainApi() {
+	gChainApiLock.Lock()
+	defer gChainApiLock.Unlock()
+	if gChainApi != nil {
+		gChainApi.Client.Close()
+	}
 	gChainApi = nil
 	gMeta = nil
 }
@@ -123,6 +133,29 @@ func GetConfigedDcStorageInfo() (programInfo *config.DcProgram, err error) {
 	return
 }
 
+// Get the dc node program information configured on the blockchain, without waiting for the chain to be synced
+func GetChainProgramInfo() (programInfo *config.DcProgram, err error) {
+	chainApi, meta, err := GetChainApi()
+	if err != nil {
+		return nil, err
+	}
+	return getRecommendProgram(chainApi, meta)
+}
+
+// Get the health(sync status and peers) of the connected chain node
+func GetChainHealth() (health types.Health, err error) {
+	chainApi, _, err := GetChainApi()
+	if err != nil {
+		return
+	}
+	h, err := chainApi.RPC.System.Health()
+	if err != nil {
+		return
+	}
+	health = h
+	return
+}
+
 // Get program version information on the current blockchain
 func getRecommendProgram(chainApi *gsrpc.SubstrateAPI, meta *types.Metadata) (programInfo *config.DcProgram, err error) {
 	key, err := types.CreateStorageKey(meta, "DcNode", "DcProgram")
@@ -311,7 +344,7 @@ func IfEnclaveIdValid(ctx context.Context, enclaveId string) (validFlag bool) {
 		return false
 	}
 	//Generate the pubkey of the technical committee
-	_, commitPubkeyBytes, err := mbase.Decode(config.CommitBasePubkey)
+	_, commitPubkeyBytes, err := mbase.Decode(config.RunningConfig.CommitBasePubkey)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v", err)
 		return
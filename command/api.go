@@ -0,0 +1,494 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dcnetio/dc/config"
+)
+
+var apiSocketFilepath string //Unix socket of the control api served by the daemon
+
+const (
+	apiEventsMax     = 1000             //events kept by the daemon for dc events
+	apiEventsWaitMax = 60 * time.Second //longest wait of a /v1/events request for new events
+)
+
+// Event recorded by the daemon: upgrade steps,alerts of the watched containers and the operations requested through the api
+type DaemonEvent struct {
+	Id      int64     `json:"id" yaml:"id"`
+	Time    time.Time `json:"time" yaml:"time"`
+	Kind    string    `json:"kind" yaml:"kind"` //upgrade,alert or service
+	Service string    `json:"service,omitempty" yaml:"service,omitempty"`
+	Message string    `json:"message" yaml:"message"`
+}
+
+func (e *DaemonEvent) String() string {
+	str := fmt.Sprintf("%s [%s]", e.Time.Format(time.RFC3339), e.Kind)
+	if e.Service != "" {
+		str += " " + e.Service + ":"
+	}
+	return str + " " + e.Message
+}
+
+// State of the upgrade returned by /v1/upgrade
+type UpgradeStateDocument struct {
+	Running     bool           `json:"running" yaml:"running"` //an upgrade is in progress in the daemon
+	LastUpgrade *UpgradeStatus `json:"lastUpgrade,omitempty" yaml:"lastUpgrade,omitempty"`
+}
+
+// Error or result message of an api request
+type apiMessage struct {
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Events of the daemon,the oldest are dropped beyond apiEventsMax
+var daemonEvents = struct {
+	sync.Mutex
+	events []DaemonEvent
+	nextId int64
+	added  chan struct{} //closed and replaced when an event is added
+}{nextId: 1, added: make(chan struct{})}
+
+// Record an event of the daemon for dc events
+func recordEvent(kind, service, format string, args ...interface{}) {
+	event := DaemonEvent{
+		Time:    time.Now(),
+		Kind:    kind,
+		Service: service,
+		Message: fmt.Sprintf(format, args...),
+	}
+	daemonEvents.Lock()
+	defer daemonEvents.Unlock()
+	event.Id = daemonEvents.nextId
+	daemonEvents.nextId++
+	daemonEvents.events = append(daemonEvents.events, event)
+	if n := len(daemonEvents.events); n > apiEventsMax {
+		daemonEvents.events = append([]DaemonEvent(nil), daemonEvents.events[n-apiEventsMax:]...)
+	}
+	close(daemonEvents.added)
+	daemonEvents.added = make(chan struct{})
+}
+
+// Get the events after the given id,and the channel closed when a new event is added
+func getEventsAfter(after int64) (events []DaemonEvent, added chan struct{}) {
+	daemonEvents.Lock()
+	defer daemonEvents.Unlock()
+	for _, event := range daemonEvents.events {
+		if event.Id > after {
+			events = append(events, event)
+		}
+	}
+	return events, daemonEvents.added
+}
+
+// Whether an upgrade of this profile is running,in the daemon or by dc upgrade now
+func upgradeInProgress() bool {
+	holder := getOpLockHolder()
+	return holder != nil && holder.Profile == config.ActiveProfile && (holder.Command == "upgrade" || holder.Command == "upgrade now")
+}
+
+// Serve the control api on the unix socket of the data dir,returns the server to close when the daemon exits
+func startApiServer() (server *http.Server, err error) {
+	os.Remove(apiSocketFilepath) //left by a daemon that did not exit cleanly,checkDcDeamonStatusDc found no running daemon
+	listener, err := net.Listen("unix", apiSocketFilepath)
+	if err != nil {
+		return
+	}
+	if err = os.Chmod(apiSocketFilepath, 0600); err != nil {
+		listener.Close()
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/status", apiStatusHandler)
+	mux.HandleFunc("POST /v1/services/{service}/start", apiStartHandler)
+	mux.HandleFunc("POST /v1/services/{service}/stop", apiStopHandler)
+	mux.HandleFunc("POST /v1/services/{services}/restart", apiRestartHandler)
+	mux.HandleFunc("POST /v1/services/{services}/recreate", apiRecreateHandler)
+	mux.HandleFunc("GET /v1/upgrade", apiUpgradeStateHandler)
+	mux.HandleFunc("POST /v1/upgrade", apiUpgradeHandler)
+	mux.HandleFunc("GET /v1/events", apiEventsHandler)
+	server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		daemonLog.Infof("serve control api on %s", apiSocketFilepath)
+		if serr := server.Serve(listener); serr != nil && serr != http.ErrServerClosed {
+			daemonLog.Errorf("control api server stopped,err: %v", serr)
+		}
+	}()
+	return
+}
+
+func writeApiJson(w http.ResponseWriter, code int, doc interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(doc)
+}
+
+func writeApiError(w http.ResponseWriter, code int, err string) {
+	writeApiJson(w, code, &apiMessage{Error: err})
+}
+
+// GET /v1/status?service={storage|chain|pccs|all}
+func apiStatusHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("service")
+	if name == "" {
+		name = "all"
+	}
+	containerNames, err := getStatusContainerNames(name)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeApiJson(w, http.StatusOK, getStatusDocument(containerNames, true))
+}
+
+// POST /v1/services/{service}/start,returns when the services are started
+func apiStartHandler(w http.ResponseWriter, r *http.Request) {
+	apiServiceOp(w, r.PathValue("service"), "start", startServicesByName)
+}
+
+// POST /v1/services/{service}/stop
+func apiStopHandler(w http.ResponseWriter, r *http.Request) {
+	apiServiceOp(w, r.PathValue("service"), "stop", stopServicesByName)
+}
+
+func apiServiceOp(w http.ResponseWriter, name, action string, op func(name string) error) {
+	if _, err := getStatusContainerNames(name); err != nil {
+		writeApiError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	lock, err := acquireOpLock(action + " " + name)
+	if err != nil {
+		writeApiError(w, http.StatusConflict, err.Error())
+		return
+	}
+	defer lock.release()
+	daemonLog.Infof("%s %s requested through the control api", action, name)
+	recordEvent("service", name, "%s requested through the control api", action)
+	if err = op(name); err != nil {
+		daemonLog.Errorf("%s %s fail,err: %v", action, name, err)
+		recordEvent("service", name, "%s fail,err: %v", action, err)
+		writeApiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	recordEvent("service", name, "%s success", action)
+	writeApiJson(w, http.StatusOK, &apiMessage{Message: fmt.Sprintf("%s %s success", action, name)})
+}
+
+// POST /v1/services/{services}/restart,services are storage,chain,pccs,upgrade,teereport or all,joined by ","
+func apiRestartHandler(w http.ResponseWriter, r *http.Request) {
+	apiServicesOp(w, r.PathValue("services"), "restart", restartService)
+}
+
+// POST /v1/services/{services}/recreate?startStopped=1,the config file is read again first,
+// so that the containers are created from the values saved by dc config set
+func apiRecreateHandler(w http.ResponseWriter, r *http.Request) {
+	startStopped := r.URL.Query().Get("startStopped") == "1"
+	apiServicesOp(w, r.PathValue("services"), "recreate", func(service string) error {
+		return recreateService(service, startStopped)
+	})
+}
+
+func apiServicesOp(w http.ResponseWriter, names, action string, op func(service string) error) {
+	services, _, err := parseServiceArgs(strings.Split(names, ","))
+	if err != nil {
+		writeApiError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	lock, err := acquireOpLock(action + " " + strings.Join(services, " "))
+	if err != nil {
+		writeApiError(w, http.StatusConflict, err.Error())
+		return
+	}
+	defer lock.release()
+	if action == "recreate" {
+		if err = reloadDaemonConfig(); err != nil {
+			writeApiError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	daemonLog.Infof("%s %s requested through the control api", action, names)
+	for _, service := range services {
+		recordEvent("service", service, "%s requested through the control api", action)
+		if err = op(service); err != nil {
+			daemonLog.Errorf("%s %s fail,err: %v", action, service, err)
+			recordEvent("service", service, "%s fail,err: %v", action, err)
+			writeApiError(w, http.StatusInternalServerError, fmt.Sprintf("%s %s service fail,err: %v", action, service, err))
+			return
+		}
+		recordEvent("service", service, "%s success", action)
+	}
+	writeApiJson(w, http.StatusOK, &apiMessage{Message: fmt.Sprintf("%s %s success", action, strings.Join(services, ","))})
+}
+
+// Read the config file again,the containers recreated by the daemon then use the values saved by dc config set.
+// The values read by the daemon itself at start (ports,paths,metrics) still take effect after a restart of the daemon.
+func reloadDaemonConfig() error {
+	newConfig, err := config.ReadConfig()
+	if err != nil {
+		return fmt.Errorf("read config file fail,err: %v", err)
+	}
+	config.RunningConfig = newConfig
+	configInvalidStatus = "" //ReadConfig returns no config that fails validation
+	return nil
+}
+
+// GET /v1/upgrade
+func apiUpgradeStateHandler(w http.ResponseWriter, r *http.Request) {
+	writeApiJson(w, http.StatusOK, &UpgradeStateDocument{
+		Running:     upgradeInProgress(),
+		LastUpgrade: getLastUpgradeStatus(),
+	})
+}
+
+// Body of POST /v1/upgrade
+type apiUpgradeRequest struct {
+	Version string `json:"version,omitempty"` //version the caller expects to be the target,empty for any
+}
+
+// POST /v1/upgrade,the daemon resolves the target program from the chain (or newVersion) and checks it like its own upgrades,
+// the posted version is only compared with the target. The upgrade runs in the background and is followed through GET /v1/upgrade
+func apiUpgradeHandler(w http.ResponseWriter, r *http.Request) {
+	req := &apiUpgradeRequest{}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(req); err != nil && err != io.EOF {
+		writeApiError(w, http.StatusBadRequest, "invalid upgrade request")
+		return
+	}
+	version, enclaveId, err := getVersionByHttpGet(dcStorageListenPort)
+	if err != nil {
+		writeApiError(w, http.StatusServiceUnavailable, "get local dcstorage version fail,please make sure storage service is running")
+		return
+	}
+	check, err := checkUpgrade(version, enclaveId)
+	if err != nil {
+		writeApiError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if !check.UpgradeNeeded {
+		writeApiError(w, http.StatusConflict, check.Reason)
+		return
+	}
+	programInfo := check.Target
+	if req.Version != "" && req.Version != programInfo.Version {
+		writeApiError(w, http.StatusConflict, fmt.Sprintf("target version is %s,not %s", programInfo.Version, req.Version))
+		return
+	}
+	lock, err := acquireOpLock("upgrade")
+	if err != nil {
+		writeApiError(w, http.StatusConflict, err.Error())
+		return
+	}
+	daemonLog.Infof("upgrade to version %s requested through the control api", programInfo.Version)
+	recordEvent("upgrade", config.ServiceStorage, "upgrade to version %s requested through the control api", programInfo.Version)
+	go func() {
+		defer lock.release()
+		upgradeAttemptsCounter.Inc()
+		if err := performUpgrade(programInfo); err != nil {
+			upgradeFailuresCounter.Inc()
+			return
+		}
+		lastUpgradeGauge.SetToCurrentTime()
+	}()
+	writeApiJson(w, http.StatusAccepted, &apiMessage{Message: fmt.Sprintf("upgrade to version %s started", programInfo.Version)})
+}
+
+// GET /v1/events?after=id&wait=30s,waits up to wait for an event after id if there is none yet
+func apiEventsHandler(w http.ResponseWriter, r *http.Request) {
+	after, _ := strconv.ParseInt(r.URL.Query().Get("after"), 10, 64)
+	wait, _ := time.ParseDuration(r.URL.Query().Get("wait"))
+	wait = min(wait, apiEventsWaitMax)
+	events, added := getEventsAfter(after)
+	if len(events) == 0 && wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-added:
+			events, _ = getEventsAfter(after)
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if events == nil {
+		events = []DaemonEvent{}
+	}
+	writeApiJson(w, http.StatusOK, events)
+}
+
+// Client of the control api,it has no timeout since starting services waits for them to be ready
+var apiClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", apiSocketFilepath)
+		},
+	},
+}
+
+// Whether the daemon is running and serves the control api
+func daemonApiAvailable() bool {
+	if apiSocketFilepath == "" {
+		return false
+	}
+	conn, err := net.DialTimeout("unix", apiSocketFilepath, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// Send a request to the control api and decode the json response into result
+func apiRequest(method, path string, body interface{}, result interface{}) (err error) {
+	var reader io.Reader
+	if body != nil {
+		content, merr := json.Marshal(body)
+		if merr != nil {
+			return merr
+		}
+		reader = bytes.NewReader(content)
+	}
+	req, err := http.NewRequest(method, "http://dc"+path, reader)
+	if err != nil {
+		return
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request daemon api fail,err: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg := &apiMessage{}
+		if derr := json.NewDecoder(resp.Body).Decode(msg); derr != nil || msg.Error == "" {
+			msg.Error = resp.Status
+		}
+		return fmt.Errorf("daemon: %s", msg.Error)
+	}
+	if result != nil {
+		err = json.NewDecoder(resp.Body).Decode(result)
+	}
+	return
+}
+
+// Get the status document from the daemon
+func apiGetStatus(name string) (doc *StatusDocument, err error) {
+	doc = &StatusDocument{}
+	err = apiRequest(http.MethodGet, "/v1/status?service="+url.QueryEscape(name), nil, doc)
+	return
+}
+
+// Start or stop the services of "dc start|stop {storage|chain|pccs|all}" in the daemon
+func apiServiceCommand(name, action string) (err error) {
+	msg := &apiMessage{}
+	if err = apiRequest(http.MethodPost, "/v1/services/"+url.PathEscape(name)+"/"+action, nil, msg); err != nil {
+		return
+	}
+	fmt.Println(msg.Message)
+	return
+}
+
+// Restart or recreate the services in the daemon
+func apiServicesCommand(services []string, action string, startStopped bool) (err error) {
+	path := "/v1/services/" + url.PathEscape(strings.Join(services, ",")) + "/" + action
+	if startStopped {
+		path += "?startStopped=1"
+	}
+	msg := &apiMessage{}
+	if err = apiRequest(http.MethodPost, path, nil, msg); err != nil {
+		return
+	}
+	fmt.Println(msg.Message)
+	return
+}
+
+// Let the daemon upgrade dcstorage to the target program and print the steps until the upgrade finishes,
+// the daemon resolves and checks the target itself and refuses it if it is not the version confirmed here
+func apiUpgrade(programInfo *config.DcProgram) (err error) {
+	msg := &apiMessage{}
+	if err = apiRequest(http.MethodPost, "/v1/upgrade", &apiUpgradeRequest{Version: programInfo.Version}, msg); err != nil {
+		return
+	}
+	fmt.Println(msg.Message)
+	lastStep := ""
+	for {
+		state := &UpgradeStateDocument{}
+		if err = apiRequest(http.MethodGet, "/v1/upgrade", nil, state); err != nil {
+			return
+		}
+		if state.LastUpgrade != nil && state.LastUpgrade.State != lastStep {
+			lastStep = state.LastUpgrade.State
+			fmt.Printf("[%s] upgrade step: %s\n", time.Now().Format("15:04:05"), lastStep)
+		}
+		if !state.Running {
+			if state.LastUpgrade != nil && state.LastUpgrade.State != upgradeStepCompleted {
+				err = fmt.Errorf("upgrade %s", state.LastUpgrade.String())
+			}
+			return
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// dc events [--follow|-f]
+// Print the events of the daemon, following them until Ctrl-C with --follow
+func EventsCommandDeal() {
+	follow := false
+	for _, arg := range os.Args[2:] {
+		if arg != "--follow" && arg != "-f" {
+			fmt.Println("usage: dc events [--follow]")
+			os.Exit(1)
+		}
+		follow = true
+	}
+	if !daemonApiAvailable() {
+		fmt.Println("daemon is not running,start it with: dc upgrade daemon")
+		os.Exit(1)
+	}
+	var after int64
+	for first := true; ; first = false {
+		var events []DaemonEvent
+		wait := ""
+		if follow && !first {
+			wait = apiEventsWaitMax.String()
+		}
+		if err := apiRequest(http.MethodGet, fmt.Sprintf("/v1/events?after=%d&wait=%s", after, wait), nil, &events); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if !follow {
+			printDocument(events, func() {
+				for _, event := range events {
+					fmt.Println(event.String())
+				}
+			})
+			return
+		}
+		for _, event := range events {
+			if OutputFormat == OutputJson { //one object per line
+				content, _ := json.Marshal(event)
+				fmt.Println(string(content))
+			} else {
+				fmt.Println(event.String())
+			}
+			after = event.Id
+		}
+	}
+}
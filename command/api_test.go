@@ -0,0 +1,63 @@
+package command
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/dcnetio/dc/util"
+)
+
+func TestApiRestartHandler(t *testing.T) {
+	savedLock := opLockFilepath
+	t.Cleanup(func() {
+		opLockFilepath = savedLock
+		util.SetContainerRuntime(nil)
+	})
+	opLockFilepath = filepath.Join(t.TempDir(), ".oplock")
+	tests := []struct {
+		name       string
+		services   string
+		heldBy     string //command holding the operation lock
+		wantCode   int
+		wantStarts int
+	}{
+		{name: "restart", services: "pccs", wantCode: http.StatusOK, wantStarts: 1},
+		{name: "several", services: "pccs,chain", wantCode: http.StatusOK, wantStarts: 2},
+		{name: "unknown service", services: "pccs,dcx", wantCode: http.StatusNotFound},
+		{name: "operation in progress", services: "pccs", heldBy: "upgrade", wantCode: http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := util.NewFakeRuntime()
+			util.SetContainerRuntime(fake)
+			pccs := addTestContainer(t, fake, pccsContainerName, "dcnetio/pccs:latest", true)
+			chain := addTestContainer(t, fake, chainContainerName, "dcnetio/dcchain:latest", true)
+			if tt.heldBy != "" {
+				lock, err := acquireOpLock(tt.heldBy)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer lock.release()
+			}
+			fake.Calls = nil
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST /v1/services/{services}/restart", apiRestartHandler)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/services/"+tt.services+"/restart", nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("code = %d,want %d,body: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			starts := 0
+			for _, call := range fake.Calls {
+				if call == "ContainerStart "+pccs.ID || call == "ContainerStart "+chain.ID {
+					starts++
+				}
+			}
+			if starts != tt.wantStarts {
+				t.Errorf("starts = %d,want %d,calls: %v", starts, tt.wantStarts, fake.Calls)
+			}
+		})
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"math/rand"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -20,51 +19,65 @@ import (
 	"github.com/dcnetio/dc/config"
 	"github.com/dcnetio/dc/util"
 	"github.com/dcnetio/go-substrate-rpc-client/v4/types/codec"
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/mount"
-	"github.com/docker/docker/client"
-	"github.com/docker/docker/pkg/stdcopy"
-	goversion "github.com/hashicorp/go-version"
 	logging "github.com/ipfs/go-log/v2"
-	"github.com/mitchellh/go-ps"
 	mbase "github.com/multiformats/go-multibase"
 )
 
-const dcStorageListenPort = 6667
-const dcUpgradeListenPort = 6666
+// Ports,names and paths of the managed services, namespaced by SetupProfile when a profile is active
+var dcStorageListenPort = 6667
+var dcUpgradeListenPort = 6666
 
-const nodeContainerName = "dcstorage"
-const chainContainerName = "dcchain"
-const upgradeContainerName = "dcupgrade"
+var nodeContainerName = "dcstorage"
+var chainContainerName = "dcchain"
+var upgradeContainerName = "dcupgrade"
+
+// pccs and teereportserver are host services shared by all profiles
 const teeReportServerContainerName = "teereportserver"
 const pccsContainerName = "dcpccs"
-const nodeVolume = "dcstorage"
+
+var nodeVolume = "dcstorage"
+
 const pccsVolume = "dcpccs"
 const teeReportServerVolume = "teereportserver"
-const daemonFilepath = "/opt/dcnetio/data/.dcupgradedaemon"
-const runCmdStateFilepath = "/opt/dcnetio/data/.cmdstate" //Record the status of currently running commands
+
+// State files of dcmanager and the host directories of dcchain and dcstorage, set by SetupProfile
+var daemonFilepath string
+var runCmdStateFilepath string //Record the status of currently running commands
 
 const serverhost = "127.0.0.1"
 
-const chainDataDir = "/opt/dcnetio/chaindata"
+var chainDataDir string
+var storageDisksDir string //Mounted into dcstorage as /opt/dcnetio/disks
+var storageEtcDir string   //Mounted into dcstorage as /opt/dcnetio/etc,holds dcstorage_config.yaml
 
 func ShowHelp() {
 
 	fmt.Println("dcmanager version ", config.GetVersion, "EPC Size: ", util.GetEpcSize())
 	fmt.Println("usage:  dc command [options]")
 	fmt.Println("commands:")
-	fmt.Println(" config                                  start config chainmode")
+	fmt.Println(" config [--validator=true|false] [--pccs-key=key] [--sync-mode=mode] [--yes] [--from answers.yaml]")
+	fmt.Println("                                         start config chainmode, prompts for the options not given")
+	fmt.Println("                                         \"--yes\": clean the chain data of a configured node without asking")
+	fmt.Println("                                         \"--from\": read the answers from a yaml file")
+	fmt.Println(" config show                             show all config values")
+	fmt.Println(" config get <key>                        show the value of a config key, e.g. chainImage or upgradePolicy.hold")
+	fmt.Println(" config set <key> <value> [--apply]      validate and save a config value")
+	fmt.Println("                                         \"--apply\": recreate the container using the key")
+	fmt.Println(" config services [service]               show the container specs of the services, defaults merged with the services of the config")
 	fmt.Println(" start {storage|chain|pccs|all}          start service with service_name")
 	fmt.Println("                                         \"storage\": start dcstorage service")
 	fmt.Println("                                         \"chain\": start dcchain service")
 	fmt.Println("                                         \"pccs\": start local pccs service")
-	fmt.Println("                                         \"all\": start dcstorage and dcchain service")
+	fmt.Println("                                         \"all\": start pccs, dcchain and dcstorage service in dependency order")
 	fmt.Println(" stop {storage|chain|pccs|all}           stop service  with service_name")
 	fmt.Println("                                         \"storage\": stop dcstorage service")
 	fmt.Println("                                         \"chain\": stop dcchain service")
 	fmt.Println("                                         \"pccs\": stop local pccs service")
-	fmt.Println("                                         \"all\": stop dcstorage and dcchain service")
+	fmt.Println("                                         \"all\": stop all services in reverse dependency order")
+	fmt.Println(" restart {storage|chain|pccs|upgrade|all} stop and start the existing container of the service")
+	fmt.Println(" recreate {storage|chain|pccs|upgrade|all} [--yes]")
+	fmt.Println("                                         stop,remove and create the container from the current config, volumes are kept")
+	fmt.Println("                                         \"--yes\": recreate without asking")
 	fmt.Println(" status {storage|chain|pccs|all}         check dc daemon status and  service status")
 	fmt.Println("                                         \"storage\": check dcstorage service status")
 	fmt.Println("                                         \"chain\": check dcchain service status")
@@ -75,6 +88,20 @@ func ShowHelp() {
 	fmt.Println("                                         \"chain\":  show dcchain container running log")
 	fmt.Println("                                         \"upgrade\":  show dcupgrade container running log")
 	fmt.Println("                                         \"pccs\":  show local pccs  running log")
+	fmt.Println("                                         \"--no-follow\": print the lines and exit instead of following the log")
+	fmt.Println("                                         \"--since\",\"--until\": duration (e.g. 1h) or timestamp of the lines")
+	fmt.Println("                                         \"--timestamps\": show the docker timestamp of each line")
+	fmt.Println("                                         \"--grep\": only lines matching a regular expression")
+	fmt.Println("                                         \"--level\": only lines at or above error|warn")
+	fmt.Println("                                         \"--export\": write the lines to a gzip file, e.g. dcstorage.log.gz")
+	fmt.Println(" upgrade {check|--dry-run|now [--yes]|daemon [--foreground]}  check or upgrade dcstorage version")
+	fmt.Println("                                         \"check\": show local version and the version to upgrade to")
+	fmt.Println("                                         \"--dry-run\": validate the upgrade without stopping any container")
+	fmt.Println("                                         \"now\": upgrade dcstorage immediately, \"--yes\" skips confirmation")
+	fmt.Println("                                         \"daemon\": run the background upgrade service")
+	fmt.Println("                                         \"--foreground\": run the daemon without forking, as dc.service does")
+	fmt.Println(" events [--follow]                       show the events of the daemon: upgrade steps, alerts and api operations")
+	fmt.Println("                                         \"--follow\": keep printing new events until Ctrl-C")
 	fmt.Println(" uniqueid                                show soft version and sgx enclaveid ")
 	fmt.Println(" peerinfo                                show local running peer info")
 	fmt.Println(" memusage                                show memory usage of local running peer")
@@ -86,93 +113,144 @@ func ShowHelp() {
 	fmt.Println("                                         \"--secret\":  file decode secret with base32 encoded")
 	fmt.Println(" pccs_api_key [apikey]                   get or set pccs api key,if no apikey set,will show current apikey")
 	fmt.Println(" rotate-keys                             generate new storage session keys")
+	fmt.Println("global options:")
+	fmt.Println(" --home dir                              install root instead of /opt/dcnetio, also set by DC_HOME")
+	fmt.Println(" --profile name                          use the named network profile of the config, e.g. testnet")
+	fmt.Println(" --output {json|yaml|text}               output format of status,uniqueid,peerinfo,memusage and config show/get, default text")
 }
 
 var log = logging.Logger("dcmanager")
 
 func ConfigCommandDeal() {
+	if len(os.Args) >= 3 {
+		switch os.Args[2] {
+		case "show":
+			configShowCommandDeal()
+			return
+		case "get":
+			configGetCommandDeal(os.Args[3:])
+			return
+		case "set":
+			configSetCommandDeal(os.Args[3:])
+			return
+		case "services":
+			configServicesCommandDeal(os.Args[3:])
+			return
+		}
+	}
+	answers, err := parseConfigAnswers(os.Args[2:])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	//Determine whether it has been configured
-	if config.RunningConfig.ValidatorFlag != "" {
-		fmt.Print("chainmode is already configed,continue will clean the chain data,continue?(y/n): ")
-		var input string
-		for {
-			input = ""
-			fmt.Scanln(&input)
-			input = strings.ToLower(input)
-			if input != "y" && input != "n" {
-				fmt.Print("please input y or n : ")
-				continue
-			} else {
-				break
-			}
+	if config.RunningConfig.ValidatorFlag != "" && !answers.Yes {
+		if answers.unattended {
+			fmt.Println("chainmode is already configed,use --yes to clean the chain data and configure it again")
+			os.Exit(1)
 		}
-		if input == "y" {
-			//remove dcchain docker
-			err := removeDockerContainer(chainContainerName)
-			if err != nil {
-				log.Error(err)
-				return
-			}
-			//Remove dcchain data directory
-			err = os.RemoveAll(chainDataDir)
-			if err != nil {
-				log.Error(err)
-				return
-			}
-		} else {
+		if !askForConfirm("chainmode is already configed,continue will clean the chain data,continue?(y/n): ") {
 			return
 		}
-
 	}
-	// Officially start configuring chainmode, prompting the user whether to use the current node as a verification node.
-	fmt.Print("do you want to config this node as validator node?(y/n): ")
-	var input string
-	for {
-		input = ""
-		fmt.Scanln(&input)
-		input = strings.ToLower(input)
-		if input != "y" && input != "n" {
-			fmt.Print("please input y or n : ")
-			continue
-		} else {
-			break
+	//Configure a deep copy,so that services,profiles and the policy slices of the running config stay untouched
+	//until the new config is valid and the chain data is cleaned
+	newConfig := config.RunningConfig.Clone()
+	for key, value := range answers.Set {
+		if err := newConfig.SetValue(key, value); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
 	}
-	if input == "y" {
-		config.RunningConfig.ValidatorFlag = "enable"
-		config.RunningConfig.ChainSyncMode = "full"
+	// Officially start configuring chainmode, prompting the user whether to use the current node as a verification node.
+	validator := false
+	if answers.Validator != nil {
+		validator = *answers.Validator
+	} else if answers.unattended {
+		fmt.Println("please set --validator=true|false")
+		os.Exit(1)
+	} else {
+		validator = askForConfirm("do you want to config this node as validator node?(y/n): ")
+	}
+	if validator {
+		if answers.SyncMode != "" && answers.SyncMode != "full" {
+			fmt.Println("validator node must use full sync mode")
+			os.Exit(1)
+		}
+		newConfig.ValidatorFlag = "enable"
+		newConfig.ChainSyncMode = "full"
 	} else {
-		config.RunningConfig.ValidatorFlag = "disable"
+		newConfig.ValidatorFlag = "disable"
+		if answers.SyncMode != "" {
+			newConfig.ChainSyncMode = answers.SyncMode
+		}
 	}
 	//Determine whether pccsapikey has been configured
-	if config.RunningConfig.PccsKey == "" {
+	if answers.PccsKey != "" {
+		newConfig.PccsKey = answers.PccsKey
+	} else if len(newConfig.PccsKey) < config.PccsKeyMinLength {
+		if answers.unattended {
+			fmt.Println("please set --pccs-key")
+			os.Exit(1)
+		}
 		fmt.Print("please input pccs api key: ")
 		var input string
 		for {
 			input = ""
-			fmt.Scanln(&input)
+			if _, err := fmt.Scanln(&input); err == io.EOF {
+				fmt.Println()
+				return
+			}
 			if input == "" {
 				fmt.Print("please input pccs api key: ")
 				continue
+			} else if len(input) < config.PccsKeyMinLength {
+				fmt.Printf("pccs api key must be at least %d characters,please input again: ", config.PccsKeyMinLength)
+				continue
 			} else {
 				break
 			}
 		}
-		config.RunningConfig.PccsKey = input
+		newConfig.PccsKey = input
+	}
+	if err := newConfig.Validate(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	lock := mustAcquireOpLock("config")
+	if config.RunningConfig.ValidatorFlag != "" {
+		//remove dcchain docker
+		err := removeDockerContainer(chainContainerName)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		//Remove dcchain data directory
+		err = os.RemoveAll(chainDataDir)
+		if err != nil {
+			log.Error(err)
+			return
+		}
 	}
 	//Save configuration
-	if err := config.SaveConfig(config.RunningConfig); err != nil {
+	if err := config.SaveConfig(newConfig); err != nil {
 		fmt.Fprintf(os.Stdout, "save config fail,err: %v\n", err)
-		return
+		os.Exit(1)
 	}
+	config.RunningConfig = newConfig
 	// Prompt that the configuration is complete and automatically start dcchain
 	fmt.Println("config chainmode success,starting dcchain service")
-	err := startDcChain()
-	if err == nil {
-		showContainerLog(chainContainerName, 100)
-	} else {
+	err = startDcChain()
+	lock.release()
+	if err != nil {
 		log.Error(err)
+		os.Exit(1)
 	}
+	if answers.unattended { //no terminal to follow the log
+		fmt.Println("dcchain service started,use \"dc log chain\" to show its log")
+		return
+	}
+	showContainerLog(chainContainerName, 100)
 }
 
 func StartCommandDeal() {
@@ -180,41 +258,58 @@ func StartCommandDeal() {
 		ShowHelp()
 		return
 	}
-	switch os.Args[2] {
-	case "storage":
-		err := startDcStorageNode()
-		if err == nil {
-			setDcnodeCmdState("start")
-			showContainerLog(nodeContainerName, 100)
-		} else {
-			log.Error(err)
-		}
-
+	name := os.Args[2]
+	logContainerName := nodeContainerName
+	switch name {
+	case "storage", "all":
 	case "chain":
-		err := startDcChain()
-		if err == nil {
-			showContainerLog(chainContainerName, 100)
-		}
+		logContainerName = chainContainerName
 	case "pccs":
-		err := runPccsInDocker()
-		if err == nil {
-			showContainerLog(pccsContainerName, 100)
-		}
+		logContainerName = pccsContainerName
+	default:
+		ShowHelp()
+		return
+	}
+	var err error
+	if daemonApiAvailable() { //The daemon runs the start,so that it does not interleave with an upgrade
+		err = apiServiceCommand(name, "start")
+	} else {
+		lock := mustAcquireOpLock("start", name)
+		err = startServicesByName(name)
+		lock.release()
+	}
+	if err != nil {
+		fmt.Println(err)
+		log.Error(err)
+		return
+	}
+	showContainerLog(logContainerName, 100)
+}
 
+// Start the services of "dc start {storage|chain|pccs|all}",used by the command and the control api of the daemon
+func startServicesByName(name string) (err error) {
+	//Services are started after the services they depend on are ready: pccs -> teereport -> dcchain -> dcstorage -> dcupgrade.
+	//A single service is started with the services it can not run without only
+	switch name {
+	case "storage":
+		err = startServices([]string{config.ServiceStorage}, serviceRuntimeDependencies)
+	case "chain":
+		err = startServices([]string{config.ServiceChain}, serviceRuntimeDependencies)
+	case "pccs":
+		err = startServices([]string{config.ServicePccs}, serviceRuntimeDependencies)
 	case "all":
-		startDcChain()
-		err := startDcStorageNode()
-		if err == nil {
-			setDcnodeCmdState("start")
-			showContainerLog(nodeContainerName, 100)
-		} else {
-			log.Error(err)
+		services := []string{config.ServiceChain, config.ServiceStorage}
+		if serviceEnabled(config.ServiceUpgrade) { //dcupgrade runs on sgx2 machines only
+			services = append(services, config.ServiceUpgrade)
 		}
-
+		err = startServices(services, serviceDependencies)
 	default:
-		ShowHelp()
+		return fmt.Errorf("unknown service: %s", name)
 	}
-
+	if err == nil && (name == "storage" || name == "all") {
+		setDcnodeCmdState("start")
+	}
+	return
 }
 
 func StopCommandDeal() {
@@ -222,22 +317,88 @@ func StopCommandDeal() {
 		ShowHelp()
 		return
 	}
-	switch os.Args[2] {
+	name := os.Args[2]
+	if _, err := getStatusContainerNames(name); err != nil {
+		ShowHelp()
+		return
+	}
+	var err error
+	if daemonApiAvailable() { //The daemon runs the stop,so that it does not interleave with an upgrade
+		err = apiServiceCommand(name, "stop")
+	} else {
+		lock := mustAcquireOpLock("stop", name)
+		err = stopServicesByName(name)
+		lock.release()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		log.Error(err)
+		os.Exit(1)
+	}
+}
+
+// Stop the services of "dc stop {storage|chain|pccs|all}",used by the command and the control api of the daemon
+func stopServicesByName(name string) (err error) {
+	switch name {
 	case "storage":
 		setDcnodeCmdState("stop")
-		stopDcnodeInDocker()
+		err = stopDcnodeInDocker()
 	case "chain":
-		stopDcchainInDocker()
+		err = stopDcchainInDocker()
 	case "pccs":
-		stopPccsInDocker()
+		err = stopPccsInDocker()
 	case "all":
+		//Reverse dependency order: dcupgrade -> dcstorage -> dcchain -> teereport -> pccs
 		setDcnodeCmdState("stop")
-		stopDcnodeInDocker()
-		stopDcchainInDocker()
-		stopPccsInDocker()
+		err = stopServices(config.ServiceNames)
 	default:
-		ShowHelp()
+		return fmt.Errorf("unknown service: %s", name)
+	}
+	return
+}
+
+// Get the containers shown by "dc status {storage|chain|pccs|all}"
+func getStatusContainerNames(name string) (containerNames []string, err error) {
+	switch name {
+	case "storage":
+		containerNames = []string{nodeContainerName}
+	case "chain":
+		containerNames = []string{chainContainerName}
+	case "pccs":
+		containerNames = []string{pccsContainerName}
+	case "all":
+		containerNames = []string{nodeContainerName, chainContainerName, pccsContainerName}
+	default:
+		err = fmt.Errorf("unknown service: %s", name)
 	}
+	return
+}
+
+// Get the status of the containers,with the version and peer of a running dcstorage if withPeer is set
+func getStatusDocument(containerNames []string, withPeer bool) *StatusDocument {
+	dcStatus, _ := checkDcDeamonStatusDc()
+	doc := &StatusDocument{
+		Daemon:      statusToString(dcStatus),
+		LastUpgrade: getLastUpgradeStatus(),
+		Operation:   getOpLockHolder(),
+		DaemonUnit:  getDaemonUnitStatus(),
+	}
+	if verr, ok := config.RunningConfig.Validate().(config.ValidationError); ok {
+		doc.InvalidConfig = verr.Fields()
+	}
+	for _, containerName := range containerNames {
+		cStatus := getContainerStatus(containerName)
+		doc.Services = append(doc.Services, cStatus)
+		if withPeer && containerName == nodeContainerName && cStatus.Running {
+			if version, enclaveId, err := getVersionByHttpGet(dcStorageListenPort); err == nil {
+				doc.Storage = &ProgramVersion{Version: version, EnclaveId: enclaveId}
+			}
+			if peerInfo, err := getPeerInfo(); err == nil {
+				doc.Peer = peerInfo
+			}
+		}
+	}
+	return doc
 }
 
 // Get the running status of the program
@@ -250,28 +411,51 @@ func StatusCommandDeal() {
 	if len(os.Args) > 2 {
 		secondArgs = os.Args[2]
 	}
-	dcStatus, _ := checkDcDeamonStatusDc()
-	fmt.Println("daemon status:", statusToString(dcStatus))
-	switch secondArgs {
-	case "storage":
-		nodeStatus, _ := checkDcnodeStatus()
-		fmt.Println("dcstorage status:", statusToString(nodeStatus))
-	case "chain":
-		chainStatus, _ := checkDcchainStatus()
-		fmt.Println("dcchain status:", statusToString(chainStatus))
-	case "pccs":
-		pccsStatus, _ := checkPccsStatus()
-		fmt.Println("pccs status:", statusToString(pccsStatus))
-	case "all":
-		nodeStatus, _ := checkDcnodeStatus()
-		fmt.Println("dcstorage status:", statusToString(nodeStatus))
-		chainStatus, _ := checkDcchainStatus()
-		fmt.Println("dcchain status:", statusToString(chainStatus))
-		pccsStatus, _ := checkPccsStatus()
-		fmt.Println("pccs status:", statusToString(pccsStatus))
-	default:
+	containerNames, err := getStatusContainerNames(secondArgs)
+	if err != nil {
 		ShowHelp()
+		return
+	}
+	var doc *StatusDocument
+	if daemonApiAvailable() {
+		if doc, err = apiGetStatus(secondArgs); err != nil {
+			log.Errorf("get status from daemon fail,err: %v", err)
+			doc = nil
+		}
 	}
+	if doc == nil {
+		doc = getStatusDocument(containerNames, OutputFormat != OutputText)
+	}
+	printDocument(doc, func() {
+		fmt.Println("daemon status:", doc.Daemon)
+		if unit := doc.DaemonUnit; unit != nil {
+			unitState := fmt.Sprintf("  %s: %s (%s)", unit.Unit, unit.ActiveState, unit.SubState)
+			if unit.StatusText != "" {
+				unitState += ", " + unit.StatusText
+			}
+			fmt.Println(unitState)
+		}
+		for _, cStatus := range doc.Services {
+			switch cStatus.Name {
+			case nodeContainerName:
+				fmt.Println("dcstorage status:", statusToString(cStatus.Running))
+			case chainContainerName:
+				fmt.Println("dcchain status:", statusToString(cStatus.Running))
+			case pccsContainerName:
+				fmt.Println("pccs status:", statusToString(cStatus.Running))
+			}
+			printContainerDetails(cStatus)
+		}
+		if doc.LastUpgrade != nil {
+			fmt.Println("last upgrade:", doc.LastUpgrade.String())
+		}
+		if doc.Operation != nil {
+			fmt.Println((&opInProgressError{Holder: doc.Operation}).Error())
+		}
+		if len(doc.InvalidConfig) > 0 {
+			fmt.Printf("invalid config: %s,fix it with dc config\n", strings.Join(doc.InvalidConfig, ","))
+		}
+	})
 }
 
 func statusToString(status bool) string {
@@ -282,30 +466,6 @@ func statusToString(status bool) string {
 	}
 }
 
-// Print the real-time running log of a specific program
-func LogCommandDeal() { //
-	if len(os.Args) < 3 {
-		ShowHelp()
-		return
-	}
-	tnum := 100
-	if len(os.Args) > 3 {
-		tnum, _ = strconv.Atoi(os.Args[3])
-	}
-	switch os.Args[2] {
-	case "storage":
-		showContainerLog(nodeContainerName, tnum)
-	case "chain":
-		showContainerLog(chainContainerName, tnum)
-	case "upgrade":
-		showContainerLog(upgradeContainerName, tnum)
-	case "pccs":
-		showContainerLog(pccsContainerName, tnum)
-	default:
-		ShowHelp()
-	}
-}
-
 // Upgrade command processing
 func UpgradeCommandDeal() {
 	if len(os.Args) > 2 {
@@ -314,24 +474,33 @@ func UpgradeCommandDeal() {
 			flag, _ := checkDcDeamonStatusDc()
 			if flag {
 				log.Info("daemon is already running")
+				fmt.Println("daemon is already running")
 				return
 			}
-			//fork new process to run in deamon mode
-			if os.Getppid() != 1 {
-				// Convert the execution file path in the command line parameters into a usable path
-				cmd := exec.Command(os.Args[0], "upgrade", "daemon")
+			//systemd (dc.service) runs the daemon in the foreground,only a daemon started from a terminal forks into the background
+			foreground := (len(os.Args) > 3 && os.Args[3] == "--foreground") || util.RunBySystemd() || os.Getppid() == 1
+			if !foreground {
+				cmd := exec.Command(os.Args[0], append(GlobalArgs(), "upgrade", "daemon", "--foreground")...)
 				cmd.SysProcAttr = &syscall.SysProcAttr{
 					Setpgid: true,
 					Pgid:    0,
 				}
 				cmd.Start() // Start executing a new process without waiting for the new process to exit
 				os.Exit(0)
-			} else {
-				daemonCommandDeal()
 			}
+			daemonCommandDeal()
+		} else if os.Args[2] == "check" { //Show the local and target dcstorage version
+			upgradeCheckCommandDeal()
+		} else if os.Args[2] == "--dry-run" { //Validate the upgrade without touching any container
+			upgradeDryRunCommandDeal()
+		} else if os.Args[2] == "now" { //Run the upgrade immediately in the foreground
+			yes := len(os.Args) > 3 && (os.Args[3] == "--yes" || os.Args[3] == "-y")
+			upgradeNowCommandDeal(yes)
 		} else {
 			ShowHelp()
 		}
+	} else {
+		ShowHelp()
 	}
 }
 
@@ -367,37 +536,43 @@ func UniqueIdCommandDeal() {
 			log.Error(err)
 		}
 	}
-	fmt.Println("dcmanager version ", config.GetVersion)
+	doc := &UniqueIdDocument{DcmanagerVersion: config.GetVersion}
+	if storageVersion != "" || storageEnclaveId != "" {
+		doc.Storage = &ProgramVersion{Version: storageVersion, EnclaveId: storageEnclaveId}
+	}
 	if upgradeVersion != "" {
-		fmt.Printf(fmtStr, storageVersion, storageEnclaveId, upgradeVersion, upgradeEnclaveId)
-	} else {
-		fmt.Printf(fmtStrStorage, storageVersion, storageEnclaveId)
+		doc.Upgrade = &ProgramVersion{Version: upgradeVersion, EnclaveId: upgradeEnclaveId}
 	}
+	printDocument(doc, func() {
+		fmt.Println("dcmanager version ", config.GetVersion)
+		if upgradeVersion != "" {
+			fmt.Printf(fmtStr, storageVersion, storageEnclaveId, upgradeVersion, upgradeEnclaveId)
+		} else {
+			fmt.Printf(fmtStrStorage, storageVersion, storageEnclaveId)
+		}
+	})
 }
 
 // Get node information running locally
 func PeerInfoCommandDeal() {
-	peerid, pubkey, walletAddr, err := getPeerInfoByHttpGet()
-	if err != nil {
-		fmt.Println("get peerinfo failed,please make sure storage service is running")
-		return
-	}
-	_, account, err := mbase.Decode(pubkey)
+	peerInfo, err := getPeerInfo()
 	if err != nil {
-		fmt.Println("decode pubkey failed")
+		exitWithError(err.Error())
 	}
-	hexAccount := codec.HexEncodeToString(account)
-	fmt.Printf("peer ID: %s\npeer Pubkey: %s\npeer Account: %s\npeer Wallet Address: %s\n", peerid, pubkey, hexAccount, walletAddr)
+	printDocument(peerInfo, func() {
+		fmt.Printf("peer ID: %s\npeer Pubkey: %s\npeer Account: %s\npeer Wallet Address: %s\n", peerInfo.PeerId, peerInfo.Pubkey, peerInfo.Account, peerInfo.WalletAddress)
+	})
 }
 
 // Get the current memory usage of the node running locally
 func MemoryUsageCommandDeal() {
 	memUsageInfo, err := getMemoryUsageByHttpGet()
 	if err != nil {
-		fmt.Println("get memory usage failed,please make sure storage service is running")
-		return
+		exitWithError("get memory usage failed,please make sure storage service is running")
 	}
-	fmt.Printf("dcstorage memory usage: %s\n", memUsageInfo)
+	printDocument(&MemoryUsageDocument{MemoryUsage: memUsageInfo}, func() {
+		fmt.Printf("dcstorage memory usage: %s\n", memUsageInfo)
+	})
 }
 
 // Manually start the block recycling command of dcstorage
@@ -500,6 +675,10 @@ func RotateKeyCommandDeal() (sessionKey string, err error) {
 func PccsApiKeyCommandDeal() {
 	if len(os.Args) >= 3 { //Need to set pccsapikey
 		apiKey := os.Args[2]
+		if len(apiKey) < config.PccsKeyMinLength {
+			fmt.Fprintf(os.Stdout, "invalid pccs api key,it must be at least %d characters\n", config.PccsKeyMinLength)
+			return
+		}
 		config.RunningConfig.PccsKey = apiKey
 		if err := config.SaveConfig(config.RunningConfig); err != nil {
 			fmt.Fprintf(os.Stdout, "save config fail,err: %v\n", err)
@@ -514,11 +693,10 @@ func PccsApiKeyCommandDeal() {
 // Get the running status of dcstorage
 func checkDcnodeStatus() (status bool, err error) {
 	status = false
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	cli, err := util.GetContainerRuntime()
 	if err != nil {
 		return
 	}
-	defer cli.Close()
 	containerId, err := findContainerIdByName(nodeContainerName)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "find container:%s id error: %v\n", nodeContainerName, err)
@@ -537,11 +715,10 @@ func checkDcnodeStatus() (status bool, err error) {
 // Get the running status of dcchain
 func checkDcchainStatus() (status bool, err error) {
 	status = false
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	cli, err := util.GetContainerRuntime()
 	if err != nil {
 		return
 	}
-	defer cli.Close()
 	containerId, err := findContainerIdByName(chainContainerName)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "find container:%s id error: %v\n", chainContainerName, err)
@@ -557,30 +734,6 @@ func checkDcchainStatus() (status bool, err error) {
 	return
 }
 
-// Get the running status of dcmanager
-func checkDcDeamonStatusDc() (status bool, err error) {
-	// Look for the dcmanager process.
-	status = false
-	//read content from .dcdaemon
-	content, err := os.ReadFile(daemonFilepath)
-	if err != nil {
-		return
-	}
-	//get pid from .dcdaemon
-	pid, err := strconv.Atoi(string(content))
-	if err != nil {
-		log.Errorf("get pid from %s  error:%v", daemonFilepath, err)
-		return
-	}
-	//check if the pid is running
-	p, err := ps.FindProcess(pid)
-	if err != nil || p == nil {
-		return
-	}
-	status = true
-	return
-}
-
 // Get the command status of dcnode. The background service will automatically start dcnode only when dcnode is running.
 func checkDcnodeCmdState() (status bool) {
 	status = false
@@ -610,11 +763,10 @@ func setDcnodeCmdState(state string) (err error) {
 // Get the running status of pccs
 func checkPccsStatus() (status bool, err error) {
 	status = false
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	cli, err := util.GetContainerRuntime()
 	if err != nil {
 		return
 	}
-	defer cli.Close()
 	containerId, err := findContainerIdByName(pccsContainerName)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "find container:%s id error: %v\n", pccsContainerName, err)
@@ -636,76 +788,87 @@ func checkPccsStatus() (status bool, err error) {
 
 // Background upgrade tracking processing
 func daemonCommandDeal() {
-	_, err := os.Stat(daemonFilepath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			os.Create(daemonFilepath)
-		} else {
-			log.Errorf("check dcmanager daemon status fail,err: %v\n", err)
-			return
-		}
-	}
-	//read content from .dcdaemon
-	content, err := os.ReadFile(daemonFilepath)
+	daemonLock, err := acquireDaemonLock()
 	if err != nil {
-		log.Errorf("read .dcdaemon file error:%v\n", err)
+		daemonLog.Info(err)
+		fmt.Println(err)
 		return
 	}
-	//check if the content is empty
-	if len(content) == 0 {
-		//write the current pid to .dcdaemon
-		err = os.WriteFile(daemonFilepath, []byte(strconv.Itoa(os.Getpid())), 0644)
-		if err != nil {
-			log.Errorf("write .dcdaemon file error:%v\n", err)
-			return
-		}
-	} else {
-		//check if the pid is running
-		pid, err := strconv.Atoi(string(content))
-		if err != nil {
-			log.Errorf("read .dcdaemon file error:%v\n", err)
-			return
-		}
-		//check if the pid is running
-		p, err := ps.FindProcess(pid)
-		if err == nil && p != nil {
-			log.Infof("dcmanager daemon already on running\n")
-			return
-		}
-		//write the current pid to .dcdaemon
-		err = os.WriteFile(daemonFilepath, []byte(strconv.Itoa(os.Getpid())), 0644)
-		if err != nil {
-			log.Errorf("write .dcdaemon file error:%v\n", err)
-			return
-		}
-	}
+	defer daemonLock.Close()
+	checkDaemonConfig()
+	//serve prometheus metrics
+	startMetricsServer()
+	//serve the control api used by the commands while the daemon is running
+	apiServer, err := startApiServer()
+	if err != nil {
+		daemonLog.Errorf("start control api fail,err: %v", err)
+	}
+	//Ready once the control api answers,the upgrade checks run in the background
+	daemonAlive(daemonTickTimeout)
+	notifyDaemonReady()
+	setDaemonStatus("started")
+	//Finish an upgrade interrupted by the previous daemon,retried on the next upgrade tick while a command holds the operation lock
+	resumePending := !resumeUpgradeLocked()
 	//start upgrade
 	ticker := time.NewTicker(time.Minute * 5)
+	watchTicker := time.NewTicker(restartWatchInterval)
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	for {
+		daemonAlive(daemonIdleTimeout)
 		select {
+		case now := <-watchTicker.C:
+			daemonAlive(daemonTickTimeout)
+			//Alert on unhealthy containers and back off containers in a restart loop,not while an operation changes the containers
+			if lock, err := acquireOpLock("watch"); err == nil {
+				watchRestartLoops(now)
+				lock.release()
+			}
 		case <-ticker.C:
+			daemonAlive(daemonTickTimeout)
+			if resumePending {
+				if resumePending = !resumeUpgradeLocked(); resumePending {
+					continue
+				}
+			}
 			if !checkDcnodeCmdState() { //The dcnode does not have a start command, which means it is shut down manually and no background upgrade service is performed.
-				log.Info("dcnode is not start,skip upgrade")
+				daemonLog.Info("dcnode is not start,skip upgrade")
+				setDaemonStatus("dcstorage is stopped by command,upgrades are paused")
 				continue
 			}
-			err = upgradeDeal()
-			if err != nil || !util.IsSgx2Support() { //If the upgrade fails or does not support sgx2.0, you can quickly enter the next upgrade check because the upgrade interruption time of nodes that do not support sgx2.0 is shorter.
+			//Operations of the commands and the control api hold the operation lock,the upgrade waits for them
+			lock, err := acquireOpLock("upgrade check")
+			if err != nil {
+				daemonLog.Infof("skip upgrade check,%v", err)
 				continue
 			}
-			//In order to prevent all nodes from being upgraded at the same time during the upgrade process, wait for a random period of time, and the maximum waiting time is 24 hours.
-			r := rand.New(rand.NewSource(time.Now().UnixNano()))
-			randnum := r.Int31n(int32(86400))
-			log.Infof("sleep %d seconds\n", randnum)
-			time.Sleep(time.Duration(randnum) * time.Second)
+			//The upgrade policy (maintenance windows,rollout delay derived from the peer id,hold) decides when an upgrade may run, so that all nodes are not upgraded at the same time.
+			upgradeDeal(lock)
+			lock.release()
 		case <-quit:
-			os.Remove(daemonFilepath)
-			os.Exit(1)
+			util.SdNotify("STOPPING=1")
+			if apiServer != nil {
+				apiServer.Close()
+			}
+			util.CloseContainerRuntime()
+			daemonLock.Close()
+			os.Exit(0) //stopped on purpose,systemctl stop must not leave the unit failed
 		}
 	}
 }
 
+// Resume an interrupted upgrade under the operation lock,returns false if the lock is held by another operation
+func resumeUpgradeLocked() bool {
+	lock, err := acquireOpLock("upgrade")
+	if err != nil {
+		daemonLog.Infof("resume upgrade later,%v", err)
+		return false
+	}
+	defer lock.release()
+	resumeUpgrade()
+	return true
+}
+
 // Start dcstorage, and when the d flag is true, start the background upgrade service
 func startDcStorageNode() (err error) {
 	//Determine whether pccs (docker) is already running. If it is not running, it needs to be run first.
@@ -732,88 +895,12 @@ func startDcChain() error {
 
 // start dcstorage in docker
 func startDcnodeInDocker() (err error) {
-	ctx := context.Background()
-	_, err = util.CreateVolume(ctx, nodeVolume)
-	if err != nil {
-		return
-	}
-	logConfig := container.LogConfig{
-		Type: "json-file",
-		Config: map[string]string{
-			"max-size": "100m",
-			"max-file": "3",
-		},
-	}
-	dataMount := mount.Mount{
-		Type:   mount.TypeVolume,
-		Source: nodeVolume,
-		Target: "/opt/dcnetio/data",
-	}
-	disksMount := mount.Mount{
-		Type:        mount.TypeBind,
-		Source:      "/opt/dcnetio/disks",
-		Target:      "/opt/dcnetio/disks",
-		Consistency: mount.ConsistencyDefault,
-		BindOptions: &mount.BindOptions{
-			Propagation: mount.PropagationShared,
-		},
-	}
-	etcMount := mount.Mount{
-		Type:   mount.TypeBind,
-		Source: "/opt/dcnetio/etc",
-		Target: "/opt/dcnetio/etc",
-	}
-	hostConfig := &container.HostConfig{
-		RestartPolicy: container.RestartPolicy{
-			Name: "always",
-		},
-		Mounts:      []mount.Mount{dataMount, disksMount, etcMount},
-		NetworkMode: "host",
-		Resources: container.Resources{
-			Devices: []container.DeviceMapping{
-				{
-					PathOnHost:        "/dev/sgx/enclave",
-					PathInContainer:   "/dev/sgx/enclave",
-					CgroupPermissions: "rwm",
-				},
-				{
-					PathOnHost:        "/dev/sgx/provision",
-					PathInContainer:   "/dev/sgx/provision",
-					CgroupPermissions: "rwm",
-				},
-			},
-		},
-		LogConfig: logConfig,
-	}
-	//Determine whether sgx is supported. In the early debugging stage, machines that do not support sgx can also run dcstorage.
-	if !util.IsSgxSupport() {
-		hostConfig = &container.HostConfig{
-			RestartPolicy: container.RestartPolicy{
-				Name: "always",
-			},
-			Mounts:      []mount.Mount{dataMount, disksMount, etcMount},
-			NetworkMode: "host",
-			LogConfig:   logConfig,
-		}
-	}
-	containerConfig := &container.Config{ //Run the enclave in non-sgx2 simulation state, and use plug-in programs for node authentication (the machine must be in an environment supervised by the committee to maximize the performance of the machine that does not support sgx2, and it is only for the sgx1 device in the original debugging environment before going online. All subsequent devices must be support sgx2)
-		Image:      config.RunningConfig.NodeImage,
-		Entrypoint: []string{"dcstorage_native"},
-	}
-	//Determine whether sgx2 is supported
-	if util.IsSgx2Support() {
-		containerConfig = &container.Config{
-			Image:      config.RunningConfig.NodeImage,
-			Entrypoint: []string{"dcstorage"},
-		}
-	}
-
 	//start container
-	err = util.StartContainer(ctx, nodeContainerName, false, containerConfig, hostConfig)
+	err = startServiceContainer(config.ServiceStorage, false)
 	if err != nil {
 		conflictMsg := fmt.Sprintf("Conflict. The container name \"/%s\" is already in use by container", nodeContainerName)
 		if strings.Contains(err.Error(), conflictMsg) {
-			err = fmt.Errorf("container %s already exist,please manual remove it first", nodeContainerName)
+			err = fmt.Errorf("container %s already exist with another image,use \"dc recreate storage\" to create it from the current config", nodeContainerName)
 		}
 	}
 	return
@@ -821,207 +908,56 @@ func startDcnodeInDocker() (err error) {
 
 // start dcchain in docker
 func startDcchainInDocker() (err error) {
-	ctx := context.Background()
-	logConfig := container.LogConfig{
-		Type: "json-file",
-		Config: map[string]string{
-			"max-size": "100m",
-			"max-file": "3",
-		},
-	}
-
-	_, err = os.Stat(chainDataDir)
-	if err != nil {
-		if os.IsNotExist(err) { //file does not exist
-			//Create a directory
-			err = os.MkdirAll(chainDataDir, os.ModePerm)
-			if err != nil {
-				return
-			}
-		} else {
-			return
-		}
-	}
-	dataMount := mount.Mount{
-		Type:        mount.TypeBind,
-		Source:      chainDataDir,
-		Target:      chainDataDir,
-		Consistency: mount.ConsistencyDefault,
-		BindOptions: &mount.BindOptions{
-			Propagation: mount.PropagationShared,
-		},
-	}
-	hostConfig := &container.HostConfig{
-		RestartPolicy: container.RestartPolicy{
-			Name: "always",
-		},
-		Mounts:      []mount.Mount{dataMount},
-		NetworkMode: "host",
-		LogConfig:   logConfig,
-	}
-	var entrypoint []string
-	entrypoint = append(entrypoint, "dcchain")
-	entrypoint = append(entrypoint, "--chain=mainnet")
-	entrypoint = append(entrypoint, "--port")
-	entrypoint = append(entrypoint, "60666")
-	if len(config.RunningConfig.ChainBootNode) > 20 {
-		entrypoint = append(entrypoint, "--bootnodes")
-		entrypoint = append(entrypoint, config.RunningConfig.ChainBootNode)
-	}
 	if config.RunningConfig.ValidatorFlag == "enable" {
-		entrypoint = append(entrypoint, "--state-pruning")
-		entrypoint = append(entrypoint, "archive")
-		entrypoint = append(entrypoint, "--blocks-pruning")
-		entrypoint = append(entrypoint, "archive")
-		entrypoint = append(entrypoint, "--validator")
 		fmt.Println("start dcchain with validator mode")
 	}
-	if config.RunningConfig.ChainExposeFlag == "enable" {
-		entrypoint = append(entrypoint, "--unsafe-rpc-external")
-		entrypoint = append(entrypoint, "--rpc-cors")
-		entrypoint = append(entrypoint, "all")
-	}
-
-	entrypoint = append(entrypoint, "-d")
-	entrypoint = append(entrypoint, chainDataDir)
-	entrypoint = append(entrypoint, "--sync")
-	if config.RunningConfig.ChainSyncMode != "" {
-		entrypoint = append(entrypoint, config.RunningConfig.ChainSyncMode)
-	} else {
-		entrypoint = append(entrypoint, "full")
-	}
-	entrypoint = append(entrypoint, "--pool-limit")
-	entrypoint = append(entrypoint, "819200")
-	entrypoint = append(entrypoint, "--pool-kbytes")
-	entrypoint = append(entrypoint, "2048000")
-	entrypoint = append(entrypoint, "--rpc-max-subscriptions-per-connection")
-	entrypoint = append(entrypoint, "1024000")
-	entrypoint = append(entrypoint, "--name")
-	entrypoint = append(entrypoint, config.RunningConfig.ChainNodeName)
-
-	containerConfig := &container.Config{
-		Image:      config.RunningConfig.ChainImage,
-		Entrypoint: entrypoint,
-	}
 	//start container
-	err = util.StartContainer(ctx, chainContainerName, true, containerConfig, hostConfig)
+	err = startServiceContainer(config.ServiceChain, true)
 	return
 
 }
 
 // start dcupgrade in docker
 func startDcupgradeInDocker() (err error) {
-	ctx := context.Background()
-	logConfig := container.LogConfig{
-		Type: "json-file",
-		Config: map[string]string{
-			"max-size": "10m",
-			"max-file": "3",
-		},
-	}
-	hostConfig := &container.HostConfig{
-		RestartPolicy: container.RestartPolicy{
-			Name: "always",
-		},
-		NetworkMode: "host",
-		Resources: container.Resources{
-			Devices: []container.DeviceMapping{
-				{
-					PathOnHost:        "/dev/sgx/enclave",
-					PathInContainer:   "/dev/sgx/enclave",
-					CgroupPermissions: "rwm",
-				},
-				{
-					PathOnHost:        "/dev/sgx/provision",
-					PathInContainer:   "/dev/sgx/provision",
-					CgroupPermissions: "rwm",
-				},
-			},
-		},
-		LogConfig: logConfig,
-	}
-	containerConfig := &container.Config{
-		Image: config.RunningConfig.UpgradeImage,
-	}
 	//start container
-	util.StartContainer(ctx, upgradeContainerName, true, containerConfig, hostConfig)
+	startServiceContainer(config.ServiceUpgrade, true)
 	return
 }
 
 // start teeReportServer in docker
 func startTeeReportServerDocker() (err error) {
-	ctx := context.Background()
-	_, err = util.CreateVolume(ctx, teeReportServerVolume)
-	if err != nil {
-		return
-	}
-	logConfig := container.LogConfig{
-		Type: "json-file",
-		Config: map[string]string{
-			"max-size": "10m",
-			"max-file": "3",
-		},
-	}
-	dataMount := mount.Mount{
-		Type:   mount.TypeVolume,
-		Source: teeReportServerVolume,
-		Target: "/opt/dcnetio/dcteereportserver",
-	}
-	hostConfig := &container.HostConfig{
-		RestartPolicy: container.RestartPolicy{
-			Name: "always",
-		},
-		NetworkMode: "host",
-		Mounts:      []mount.Mount{dataMount},
-		Resources: container.Resources{
-			Devices: []container.DeviceMapping{
-				{
-					PathOnHost:        "/dev/sgx/enclave",
-					PathInContainer:   "/dev/sgx/enclave",
-					CgroupPermissions: "rwm",
-				},
-				{
-					PathOnHost:        "/dev/sgx/provision",
-					PathInContainer:   "/dev/sgx/provision",
-					CgroupPermissions: "rwm",
-				},
-			},
-		},
-		LogConfig: logConfig,
-	}
-	containerConfig := &container.Config{
-		Image:      config.RunningConfig.TeeReportServerImage,
-		Entrypoint: []string{"dcteereportserver"},
-	}
 	//start container
-	err = util.StartContainer(ctx, teeReportServerContainerName, true, containerConfig, hostConfig)
+	err = startServiceContainer(config.ServiceTeeReport, true)
 	return
 }
 
 // stopDcnodeInDocker stop dcstorage in docker
 func stopDcnodeInDocker() (err error) {
+	quiesceDcstorage()
 	ctx := context.Background()
 	err = util.StopContainer(ctx, nodeContainerName, 60)
 	return
 }
 
 // stop dcupgrade in docker
-func stopUpgradeInDocker() {
+func stopUpgradeInDocker() (err error) {
 	ctx := context.Background()
-	util.StopContainer(ctx, upgradeContainerName, 10)
+	err = util.StopContainer(ctx, upgradeContainerName, 10)
+	return
 }
 
 // stop dcchain in docker
-func stopDcchainInDocker() {
+func stopDcchainInDocker() (err error) {
 	ctx := context.Background()
-	util.StopContainer(ctx, chainContainerName, 60)
+	err = util.StopContainer(ctx, chainContainerName, 60)
+	return
 }
 
 // stop dcpccs in docker
-func stopPccsInDocker() {
+func stopPccsInDocker() (err error) {
 	ctx := context.Background()
-	util.StopContainer(ctx, pccsContainerName, 10)
-
+	err = util.StopContainer(ctx, pccsContainerName, 10)
+	return
 }
 
 // remove container from docker by container name
@@ -1050,6 +986,26 @@ func getVersionByHttpGet(localport int) (version string, enclaveId string, err e
 
 }
 
+// Get the peer information of the local dcstorage, with the account decoded from the pubkey
+func getPeerInfo() (peerInfo *PeerInfo, err error) {
+	peerid, pubkey, walletAddr, err := getPeerInfoByHttpGet()
+	if err != nil {
+		err = fmt.Errorf("get peerinfo failed,please make sure storage service is running")
+		return
+	}
+	peerInfo = &PeerInfo{
+		PeerId:        peerid,
+		Pubkey:        pubkey,
+		WalletAddress: walletAddr,
+	}
+	_, account, derr := mbase.Decode(pubkey)
+	if derr != nil {
+		fmt.Fprintln(os.Stderr, "decode pubkey failed")
+	}
+	peerInfo.Account = codec.HexEncodeToString(account)
+	return
+}
+
 // Use the dcstorage program to provide local random number query service and obtain node information
 func getPeerInfoByHttpGet() (peerid, account, walletAddr string, err error) {
 	dcPeerInfoUrl := fmt.Sprintf("http://%s:%d/peerinfo", serverhost, dcStorageListenPort)
@@ -1082,6 +1038,32 @@ func getMemoryUsageByHttpGet() (memusage string, err error) {
 
 }
 
+// Ask dcstorage to flush its databases and stop accepting writes before its container is stopped,
+// so that badger is not killed in the middle of a write. dcstorage answers when it is quiesced.
+func quiesceDcstorage() {
+	timeout := config.RunningConfig.StorageQuiesceTimeout
+	if timeout <= 0 || !getContainerStatus(nodeContainerName).Running {
+		return
+	}
+	dcQuiesceUrl := fmt.Sprintf("http://%s:%d/quiesce", serverhost, dcStorageListenPort)
+	fmt.Printf("wait for dcstorage to flush (timeout %ds) ...\n", timeout)
+	log.Infof("wait for dcstorage to flush (timeout %ds) ...", timeout)
+	start := time.Now()
+	_, err := util.HttpGetWithTimeout(dcQuiesceUrl, time.Duration(timeout)*time.Second, fmt.Sprintf("timeout=%d", timeout))
+	elapsed := time.Since(start).Round(time.Millisecond)
+	switch {
+	case err == nil:
+		fmt.Printf("dcstorage flushed in %s\n", elapsed)
+		log.Infof("dcstorage flushed and quiesced in %s", elapsed)
+	case strings.Contains(err.Error(), "statuscode: 404"):
+		fmt.Println("dcstorage does not support flush,stop it directly")
+		log.Warnf("dcstorage does not support quiesce,stop it without flush")
+	default:
+		fmt.Fprintf(os.Stderr, "dcstorage flush fail after %s,stop it anyway,err: %v\n", elapsed, err)
+		log.Errorf("dcstorage quiesce fail after %s,stop it anyway,err: %v", elapsed, err)
+	}
+}
+
 // Send gc command to dcstorage
 func sendBlockGcCommand() (err error) {
 	dcPeerInfoUrl := fmt.Sprintf("http://%s:%d/blockgc", serverhost, dcStorageListenPort)
@@ -1130,6 +1112,13 @@ func waitNewDcGetPeerSecret() (bool, error) {
 		if err != nil {
 			if count%30 == 0 {
 				log.Infof("waitNewDcGetPeerSecret requset fail,  err: %v\n", err)
+				if err = checkDcstorageCrashLoop(); err != nil {
+					return false, err
+				}
+			}
+			count++
+			if count > 600 { //Wait 10 minutes
+				return false, fmt.Errorf("new version dcstorage get peer secret timeout")
 			}
 			continue
 		}
@@ -1150,9 +1139,11 @@ func waitNewDcGetPeerSecret() (bool, error) {
 var verionLogFlag = true //Upgrade log printing flag to avoid repeated printing
 var waitEnclaveIdFlag = true
 var versionGetErrCount = 0 //Number of failed attempts to obtain version information
+var policyLogReason = ""   //Last reason the upgrade policy refused an upgrade, to avoid repeated printing
+var failedLogVersion = ""  //Last version skipped because its upgrade rolled back,failed or was aborted, to avoid repeated printing
 
 // dcstorage 程序升级处理
-func upgradeDeal() (err error) {
+func upgradeDeal(lock *opLock) (err error) {
 	//Determine whether the current dcstorage is running, if not, start dcstorage
 	status, err := checkDcnodeStatus()
 	if err != nil || !status {
@@ -1190,197 +1181,93 @@ func upgradeDeal() (err error) {
 		log.Infof("dcstorage is running,version: %s,enclaveid: %s", version, enclaveId)
 	}
 	waitEnclaveIdFlag = true
-	//Get the latest configured node enclaveid on the blockchain
-	programInfo, err := blockchain.GetConfigedDcStorageInfo()
+	check, err := checkUpgrade(version, enclaveId)
 	if err != nil {
-		log.Errorf("get dcstorage version info from blockchain fail,err: %v", err)
-		return
-	}
-	bcVersion, err := goversion.NewVersion(programInfo.Version)
-	if err != nil {
-		log.Errorf("invalid new version format on blockchain,err: %v", err)
+		log.Error(err)
 		return
 	}
-	//Verify the enclaveid configured on the obtained chain
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if config.RunningConfig.NewVersion.Version != "" && config.RunningConfig.NewVersion.EnclaveId != "" { //If there is a new version number in the configuration file, use the version number in the configuration file (manual upgrade)
-		configNewVersion, err := goversion.NewVersion(config.RunningConfig.NewVersion.Version)
-		if err == nil && bcVersion.LessThan(configNewVersion) { //If the version number on the blockchain is smaller than the version number in the configuration file, use the version number in the configuration file (manual upgrade)
-			if blockchain.IfEnclaveIdValid(ctx, config.RunningConfig.NewVersion.EnclaveId) {
-				programInfo = &config.RunningConfig.NewVersion
-			}
-
-		}
-	}
+	programInfo := check.Target
 	//Determine whether the enclaveid of the currently running dcstorage is consistent with the latest configured node enclaveid on the blockchain
 	if enclaveId == programInfo.EnclaveId {
 		if verionLogFlag {
 			log.Infof("dcstorage is the latest version")
 			verionLogFlag = false
 		}
+		setDaemonStatus("dcstorage %s is the latest version", version)
 		return
 	}
 	verionLogFlag = true
-	if !blockchain.IfEnclaveIdValid(ctx, programInfo.EnclaveId) {
-		return
-	}
-	//Compare the old and new version numbers to determine whether an upgrade is needed
-	localVersion, err := goversion.NewVersion(version)
-	if err != nil {
-		log.Errorf("invalid local version format,err: %v", err)
-		return
-	}
-	configedVersion, err := goversion.NewVersion(programInfo.Version)
-	if err != nil {
-		log.Errorf("invalid new version format,err: %v", err)
-		return
-	}
-	if !localVersion.LessThan(configedVersion) { //The local version is updated, not updated
-		log.Infof("unneed upgrade ,dcstorage localVersion: %s,   configedVersion: %s\n", localVersion, configedVersion)
-		return
-	}
-	tagUrl := programInfo.OriginUrl
-	imageLoadSuccess := false
-	//Obtain the image of the upgrade assistant program. If it exists in the DC network, use the image in the DC network. Otherwise, use the image corresponding to the registry in the configuration file.
-	for _, mCid := range programInfo.MirrCids {
-		//Get the backup node address where the mcid file is located
-		fileSize, addrInfos, err := blockchain.GetPeerAddrsForCid(mCid)
-		if err != nil || len(addrInfos) == 0 {
-			continue
-		}
-		tObj := &util.TransmitObj{
-			TotalSize: uint64(fileSize),
-			LogFlag:   true,
-		}
-		savePath := fmt.Sprintf("/tmp/%s.tar", mCid)
-		err = util.DownloadFromIpfs(mCid, "", savePath, addrInfos, time.Hour, tObj)
-		if err == nil {
-			//Talk about image import obtained from DC network
-			err = loadDcStorageImage(context.Background(), savePath)
-			if err == nil {
-				imageLoadSuccess = true
-				break
-			}
+	if !check.UpgradeNeeded {
+		if check.EnclaveIdValid {
+			log.Info(check.Reason)
 		}
+		return
 	}
-	if !imageLoadSuccess {
-		// Obtain the corresponding image according to the corresponding registry address in the configuration file
-		if config.RunningConfig.Registry == "cn" {
-			tagUrl = programInfo.MirrorUrl
-		}
-		// Pull the new version of dcstorage program image
-		err = pullDcStorageNodeImage(tagUrl)
-		if err != nil {
-			if config.RunningConfig.Registry == "cn" {
-				tagUrl = programInfo.OriginUrl
-			} else {
-				tagUrl = programInfo.MirrorUrl
-			}
-			err = pullDcStorageNodeImage(tagUrl)
-			if err != nil {
-				log.Errorf("pullDcStorageNodeImage fail,err: %v", err)
-				return
-			}
+	//Follow the maintenance windows and staged rollout of the upgrade policy
+	if allowed, reason := checkUpgradePolicy(programInfo, time.Now()); !allowed {
+		if reason != policyLogReason {
+			log.Infof("skip upgrade to version %s: %s", programInfo.Version, reason)
+			policyLogReason = reason
 		}
+		setDaemonStatus("upgrade to version %s is pending: %s", programInfo.Version, reason)
+		return
 	}
-	//First close the upgrade assistant program. Because whether the upgrade is successful or not, the internal flag of dcupgrade will only be reset when restarting, so it must be closed first.
-	stopUpgradeInDocker()
-	if util.IsSgx2Support() { //Sgx2 environment, you need to introduce an upgrade assistant program to transfer the node key
-		// Run the upgrade assistant
-		err = startDcupgradeInDocker()
-		if err != nil {
-			log.Errorf("startDcupgradeInDocker fail,err: %v", err)
-			return
+	policyLogReason = ""
+	//A release that rolled back or failed is not retried by the daemon,otherwise dcstorage would be replaced on every tick
+	if j, _ := readUpgradeJournal(); j.failedFor(programInfo) {
+		if failedLogVersion != programInfo.Version {
+			log.Warnf("skip upgrade to version %s,the last upgrade to it %s at step %s,waiting for a new target or dc upgrade now", programInfo.Version, j.Step, j.FailedStep)
+			failedLogVersion = programInfo.Version
 		}
-		// Wait for dcupdate to successfully obtain the node key
-		_, err = waitDcUpdateGetPeerSecret()
-		if err != nil {
-			log.Errorf("update fail,err: %v", err)
-			return
+		setDaemonStatus("upgrade to version %s is skipped: it %s at step %s,run dc upgrade now to retry", programInfo.Version, j.Step, j.FailedStep)
+		return
+	} else if retryAt := j.abortedBackoffUntil(programInfo); time.Now().Before(retryAt) {
+		//An aborted upgrade left the old dcstorage running,it is retried with a back off as the cause may be temporary
+		if failedLogVersion != programInfo.Version {
+			log.Warnf("skip upgrade to version %s until %s,the last upgrade to it was aborted at step %s, %d times in a row", programInfo.Version, retryAt.Format(time.RFC3339), j.FailedStep, j.Aborts)
+			failedLogVersion = programInfo.Version
 		}
-	}
-	//Close the currently running dcstorage
-	err = stopDcnodeInDocker()
-	if err != nil {
-		log.Errorf("stopDcnodeInDocker fail,err: %v", err)
-		time.Sleep(10 * time.Second) //If you do not exit directly, the loop may fail due to apparmor, and you will never be able to upgrade.
-	}
-	//Delete the docker container of the old version of dcstoragenode
-	err = removeDcStorageNodeInDocker()
-	if err != nil {
-		log.Errorf("removeDcStorageNodeInDocker fail,err: %v", err)
+		setDaemonStatus("upgrade to version %s is backed off until %s: it was aborted at step %s", programInfo.Version, retryAt.Format(time.RFC3339), j.FailedStep)
 		return
 	}
-	//Update the image of dc storagenode to ensure that when starting, the new version of dcstorage is started.
-	config.RunningConfig.NodeImage = tagUrl
-	//Run the downloaded dcstorage program
-	err = startDcStorageNode()
+	failedLogVersion = ""
+	lock.setCommand("upgrade")
+	upgradeAttemptsCounter.Inc()
+	err = performUpgrade(programInfo)
 	if err != nil {
-		log.Errorf("upgrade-startDcStorageNode fail,err: %v", err)
+		upgradeFailuresCounter.Inc()
 		return
 	}
-	log.Info("wait new version to get peer secret")
-	if util.IsSgx2Support() {
-		// Wait for the new version of dcstorage to successfully obtain the node key
-		_, err = waitNewDcGetPeerSecret()
-		if err != nil {
-			log.Errorf("update fail,err: %v", err)
-			return
-		}
-		stopUpgradeInDocker()
-		log.Infof("new version dcstorage  get peer sceret success")
-	}
-	//Save the configuration file to ensure that the next time you start it, the new version of dcstorage will be started.
-	if err = config.SaveConfig(config.RunningConfig); err != nil {
-		log.Errorf("save config fail,err: %v", err)
-		return
-	}
-	//Wait for dcstorage to restart successfully after obtaining the secret. Wait up to 10 minutes.
-	log.Info("wait new version dcstorage to start with secret, max wait 10 minutes...")
-	count := 0
-	for {
-		//Determine whether the new version of the program is running normally by checking the version
-		version, enclaveId, err = getVersionByHttpGet(dcStorageListenPort)
-		if err == nil {
-			break
-		}
-		time.Sleep(10 * time.Second)
-		count++
-		if count > 60 {
-			log.Errorf("new version dcstorage start fail,err : %v", err)
-			break
-		}
-	}
-	if version != programInfo.Version {
-		log.Errorf("dcstorage version check fail,version: %s, configedVersion: %s", version, programInfo.Version)
-		return
+	lastUpgradeGauge.SetToCurrentTime()
+	//The daemon keeps running after the upgrade,it shares one docker client for its whole life instead of restarting itself to free the clients of the upgrade
+	verionLogFlag = true
+	return
+}
+
+// Switch dcstorage to the given program version through the journaled upgrade state machine
+func performUpgrade(programInfo *config.DcProgram) (err error) {
+	journal := newUpgradeJournal(programInfo)
+	if last, _ := readUpgradeJournal(); last != nil && last.Step == upgradeStepAborted && last.forProgram(programInfo) {
+		journal.Aborts = last.Aborts //keep counting the aborts in a row for the back off
 	}
-	if enclaveId != programInfo.EnclaveId && util.IsSgx2Support() {
-		log.Errorf("dcstorage enclaveid check fail,enclaveId: %s, configedEnclaveId: %s", enclaveId, programInfo.EnclaveId)
-		//Stop new version of dcstorage
-		stopDcnodeInDocker()
+	if err = journal.save(); err != nil {
+		log.Errorf("save upgrade journal fail,err: %v", err)
 		return
 	}
-	log.Infof("dcstorage upgrade success,version: %s,enclaveid: %s", version, enclaveId)
-	//dc自身重启,因为启动docker容器的时候，内存会有泄漏，无法回收,所以重启后台升级服务
-	cmd := exec.Command(os.Args[0], "upgrade", "daemon")
-	cmd.Start() // 开始执行新进程，不等待新进程退出
-	os.Exit(0)
-	return
+	return runUpgradeSteps(journal)
 }
 
 // Pull new docker image
 func pullDcStorageNodeImage(image string) (err error) {
 	//docker pull
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+	cli, err := util.GetContainerRuntime()
 	if err != nil {
 		return
 	}
 	log.Info("begin to pull new version dcstorage docker image: ", image)
 	ctx := context.Background()
 	//docker pull
-	out, err := cli.ImagePull(ctx, image, types.ImagePullOptions{})
+	out, err := cli.ImagePull(ctx, image)
 	if err != nil {
 		log.Errorf("pullDcStorageNodeImage-ImagePull fail,err: %v", err)
 		if out != nil {
@@ -1401,7 +1288,7 @@ func pullDcStorageNodeImage(image string) (err error) {
 
 // loadDcStorageImage loads dcstorage object
 func loadDcStorageImage(ctx context.Context, imagePath string) (err error) {
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+	cli, err := util.GetContainerRuntime()
 	if err != nil {
 		return
 	}
@@ -1409,10 +1296,11 @@ func loadDcStorageImage(ctx context.Context, imagePath string) (err error) {
 	imageReader, err := os.Open(imagePath)
 	if err != nil {
 		log.Error(err)
+		return
 	}
 	// close file
 	defer imageReader.Close()
-	_, err = cli.ImageLoad(ctx, imageReader, true)
+	err = cli.ImageLoad(ctx, imageReader)
 	return
 
 }
@@ -1423,49 +1311,23 @@ func loadDcStorageImage(ctx context.Context, imagePath string) (err error) {
 func removeDcStorageNodeInDocker() (err error) {
 	log.Infof("begin to remove old version dcstorage docker container")
 	fmt.Println("begin to remove old version dcstorage docker container")
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+	cli, err := util.GetContainerRuntime()
 	if err != nil {
 		return
 	}
-	//Get the docker container id of dcstorage
-	containers, err := cli.ContainerList(context.Background(), types.ContainerListOptions{All: true})
+	//Only the dcstorage container of this profile,the other profiles run the same image
+	containerId, err := util.FindContainerIdByName(context.Background(), cli, nodeContainerName, true)
 	if err != nil {
 		return
 	}
-	for _, container := range containers {
-		if container.Image == config.RunningConfig.NodeImage {
-			log.Infof("begin to remove old version dcstorage docker container,container id: %s", container.ID)
-			fmt.Printf("begin to remove old version dcstorage docker container,container id: %s\n", container.ID)
-			err = cli.ContainerRemove(context.Background(), container.ID, types.ContainerRemoveOptions{Force: true})
-			if err != nil {
-				continue
-			}
-			log.Infof("remove old version dcstorage docker container success")
+	if containerId != "" {
+		log.Infof("begin to remove old version dcstorage docker container,container id: %s", containerId)
+		fmt.Printf("begin to remove old version dcstorage docker container,container id: %s\n", containerId)
+		if err = cli.ContainerRemove(context.Background(), containerId); err != nil {
 			return
-		} else {
-			//Remove old version of dcstorage container
-			if strings.Contains(container.Image, "dcstorage") {
-				log.Infof("begin to remove old version dcstorage docker container,container id: %s", container.ID)
-				err = cli.ContainerRemove(context.Background(), container.ID, types.ContainerRemoveOptions{Force: true})
-				if err != nil {
-					continue
-				}
-				log.Infof("remove old version dcstorage docker container success")
-				return
-			}
-			for _, name := range container.Names {
-				if name == nodeContainerName {
-					log.Infof("begin to remove old version dcstorage docker container,container id: %s", container.ID)
-					err = cli.ContainerRemove(context.Background(), container.ID, types.ContainerRemoveOptions{Force: true})
-					if err != nil {
-						continue
-					}
-					log.Infof("remove old version dcstorage docker container success")
-					return
-				}
-			}
 		}
-
+		log.Infof("remove old version dcstorage docker container success")
+		return
 	}
 	log.Infof("no old version dcstorage docker container")
 	fmt.Println("no old version dcstorage docker container")
@@ -1506,28 +1368,7 @@ func runPccsInDocker() (err error) {
 		fmt.Println("start pccs fail,err: ", err.Error())
 		return
 	}
-	pcsUrl := "PCSURL=https://api.trustedservices.intel.com/sgx/certification/v4/"
-	apiKeyStr := fmt.Sprintf("APIKEY=%s", apiKey)
-	userPassStr := "USERPASS=$Dcnetio_user0$" //default password
-	adminPassStr := "ADMINPASS=$Dcnetio_admin0$"
-	ctx := context.Background()
-	dataMount := mount.Mount{
-		Type:   mount.TypeVolume,
-		Source: pccsVolume,
-		Target: "/opt/intel/pccs",
-	}
-	hostConfig := &container.HostConfig{
-		RestartPolicy: container.RestartPolicy{
-			Name: "always",
-		},
-		NetworkMode: "host",
-		Mounts:      []mount.Mount{dataMount},
-	}
-	cConfig := &container.Config{
-		Image: config.RunningConfig.PccsImage,
-		Env:   []string{pcsUrl, apiKeyStr, userPassStr, adminPassStr},
-	}
-	err = util.StartContainer(ctx, pccsContainerName, true, cConfig, hostConfig)
+	err = startServiceContainer(config.ServicePccs, true)
 	//check if pccs is running
 	if err == nil {
 		startFlag := false
@@ -1549,78 +1390,11 @@ func runPccsInDocker() (err error) {
 	return
 }
 
-// show Container log
-func showContainerLog(containerName string, tnum int) {
-	containerId, err := findContainerIdByName(containerName)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "find container id error: %v\n", err)
-		return
-	}
-	err = showLogsForContainer(containerId, tnum)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "show logs error: %v\n", err)
-		return
-	}
-}
-
-// Print the log of the specified container id in docker
-func showLogsForContainer(containerId string, tnum int) error {
-	cli, _ := client.NewClientWithOpts(client.FromEnv)
-	defer cli.Close()
-	if tnum == 0 { //Print the latest log of the container running
-		execResp, err := cli.ContainerInspect(context.Background(), containerId)
-		if err != nil { //Container does not exist
-			return err
-		}
-		reader, err := cli.ContainerLogs(context.Background(), containerId, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Follow: true, Since: execResp.State.StartedAt})
-		if err != nil {
-			return err
-		}
-		defer reader.Close()
-		_, err = io.Copy(os.Stdout, reader)
-		if err != nil && err != io.EOF {
-			return err
-		}
-
-	} else {
-		reader, err := cli.ContainerLogs(context.Background(), containerId, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Follow: true, Tail: fmt.Sprintf("%d", tnum)})
-		if err != nil {
-			return err
-		}
-		defer reader.Close()
-		_, err = stdcopy.StdCopy(os.Stdout, os.Stderr, reader)
-		//	_, err = io.Copy(os.Stdout, reader)
-		if err != nil && err != io.EOF {
-			return err
-		}
-	}
-	handleInterruptSignal()
-	return nil
-}
-
 // find container id by Name
 func findContainerIdByName(containerName string) (containerId string, err error) {
-	cli, _ := client.NewClientWithOpts(client.FromEnv)
-	containers, err := cli.ContainerList(context.Background(), types.ContainerListOptions{All: true})
+	cli, err := util.GetContainerRuntime()
 	if err != nil {
 		return
 	}
-	for _, container := range containers {
-		for _, name := range container.Names {
-			if name == "/"+containerName {
-				containerId = container.ID
-				break
-			}
-		}
-	}
-	return
-}
-
-// handle interrupt signal
-func handleInterruptSignal() {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	<-c
-	log.Info("Interrupt signal received, shutting down...")
-	os.Exit(0)
+	return util.FindContainerIdByName(context.Background(), cli, containerName, true)
 }
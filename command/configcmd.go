@@ -0,0 +1,237 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dcnetio/dc/config"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const serviceDaemon = "daemon" //the upgrade daemon run by dc.service,it reads the config when it starts
+
+// Service using each config key, keys missing here are only read when a command runs
+var configKeyServices = map[string]string{
+	"chainNodeName":         config.ServiceChain,
+	"validatorFlag":         config.ServiceChain,
+	"chainSyncMode":         config.ServiceChain,
+	"chainRpcListenPort":    config.ServiceChain,
+	"chainImage":            config.ServiceChain,
+	"chainBootNode":         config.ServiceChain,
+	"chainExposeFlag":       config.ServiceChain,
+	"nodeImage":             config.ServiceStorage,
+	"upgradeImage":          config.ServiceUpgrade,
+	"teeReportServerImage":  config.ServiceTeeReport,
+	"pccsKey":               config.ServicePccs,
+	"pccsImage":             config.ServicePccs,
+	"chainWsUrl":            serviceDaemon,
+	"registry":              serviceDaemon,
+	"metricsListenPort":     serviceDaemon,
+	"metricsListenAddr":     serviceDaemon,
+	"upgradePolicy":         serviceDaemon,
+	"newVersion":            serviceDaemon,
+	"chainSpec":             config.ServiceChain,
+	"chainP2pPort":          config.ServiceChain,
+	"chainDataDir":          config.ServiceChain,
+	"commitBasePubkey":      serviceDaemon,
+	"storageListenPort":     serviceDaemon,
+	"upgradeListenPort":     serviceDaemon,
+	"storageQuiesceTimeout": serviceDaemon,
+	"logging":               serviceDaemon,
+	"paths.disksDir":        config.ServiceStorage,
+	"paths.storageEtcDir":   config.ServiceStorage,
+	"paths.logFile":         serviceDaemon,
+	"paths.dataDir":         serviceDaemon,
+	"paths.profilesDir":     serviceDaemon,
+	"profiles":              serviceDaemon,
+}
+
+// Get the service using key, nested keys use the service of their parent
+func getConfigKeyService(key string) string {
+	if parts := strings.Split(key, "."); len(parts) > 1 && parts[0] == "services" { //services.<name>...
+		return parts[1]
+	}
+	if service, ok := configKeyServices[key]; ok {
+		return service
+	}
+	return configKeyServices[strings.Split(key, ".")[0]]
+}
+
+// dc config show
+func configShowCommandDeal() {
+	printDocument(config.RunningConfig, func() {
+		for _, key := range config.ConfigKeys() {
+			value, _ := config.RunningConfig.GetValue(key)
+			fmt.Printf("%s: %s\n", key, value)
+		}
+	})
+}
+
+// dc config services [service]: the effective container specs,defaults merged with the services of the config
+func configServicesCommandDeal(args []string) {
+	services := config.ServiceNames
+	if len(args) > 0 {
+		services = args[:1]
+	}
+	specs := map[string]*config.ServiceSpec{}
+	for _, service := range services {
+		spec, err := GetServiceSpec(service)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		specs[service] = spec
+	}
+	printDocument(specs, func() {
+		content, _ := yaml.Marshal(specs)
+		fmt.Print(string(content))
+	})
+}
+
+// dc config get <key>
+func configGetCommandDeal(args []string) {
+	if len(args) < 1 {
+		fmt.Println("usage: dc config get <key>")
+		return
+	}
+	value, err := config.RunningConfig.GetValue(args[0])
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println("keys:", strings.Join(config.ConfigKeys(), ","))
+		os.Exit(1)
+	}
+	field, _ := config.RunningConfig.GetField(args[0])
+	printDocument(map[string]interface{}{args[0]: field}, func() {
+		fmt.Println(value)
+	})
+}
+
+// dc config set <key> <value> [--apply]
+func configSetCommandDeal(args []string) {
+	apply := false
+	values := []string{}
+	for _, arg := range args {
+		if arg == "--apply" {
+			apply = true
+		} else {
+			values = append(values, arg)
+		}
+	}
+	if len(values) != 2 {
+		fmt.Println("usage: dc config set <key> <value> [--apply]")
+		return
+	}
+	key, value := values[0], values[1]
+	//Change a copy, so that the running config stays valid if the new value is rejected
+	newConfig := config.RunningConfig.Clone()
+	if err := newConfig.SetValue(key, value); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	//Only errors of the key being set are fatal, so that invalid fields can be fixed one by one
+	if verr, ok := newConfig.Validate().(config.ValidationError); ok {
+		var keyErrs config.ValidationError
+		for _, ferr := range verr {
+			if ferr.Under(key) {
+				keyErrs = append(keyErrs, ferr)
+			} else {
+				fmt.Println("warning:", ferr)
+			}
+		}
+		if len(keyErrs) > 0 {
+			fmt.Println(keyErrs)
+			os.Exit(1)
+		}
+	}
+	if err := config.SaveConfig(newConfig); err != nil {
+		fmt.Fprintf(os.Stdout, "save config fail,err: %v\n", err)
+		os.Exit(1)
+	}
+	config.RunningConfig = newConfig
+	fmt.Printf("set %s success\n", key)
+	log.Infof("config %s is set to %s", key, value)
+	service := getConfigKeyService(key)
+	switch {
+	case service == "":
+	case service == serviceDaemon:
+		fmt.Println("restart the dc service to apply it: systemctl restart dc")
+	case apply && daemonApiAvailable(): //The daemon reads the saved config and recreates the container
+		if err := apiServicesCommand([]string{service}, "recreate", false); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			log.Error(err)
+			os.Exit(1)
+		}
+	case apply:
+		lock := mustAcquireOpLock("recreate", service)
+		defer lock.release()
+		if err := recreateService(service, false); err != nil {
+			fmt.Printf("recreate %s service fail,err: %v\n", service, err)
+			log.Errorf("recreate %s service fail,err: %v", service, err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Printf("the %s service uses the new value after it is recreated, use --apply to recreate it now\n", service)
+	}
+}
+
+// Answers to the "dc config" questions, given by flags or an answer file (--from)
+type ConfigAnswers struct {
+	Validator  *bool             `yaml:"validator"` //configure the node as validator node
+	PccsKey    string            `yaml:"pccsKey"`
+	SyncMode   string            `yaml:"syncMode"`
+	Yes        bool              `yaml:"yes"` //clean the chain data of an already configured node without asking
+	Set        map[string]string `yaml:"set"` //other config values,by config key
+	unattended bool              //answers were given,never prompt
+}
+
+// Parse the options of "dc config": --validator=true|false --pccs-key=... --sync-mode=... --yes --from answers.yaml,
+// flags override the values of the answer file
+func parseConfigAnswers(args []string) (answers *ConfigAnswers, err error) {
+	answers = &ConfigAnswers{}
+	configCmd := flag.NewFlagSet("config", flag.ContinueOnError)
+	validator := configCmd.String("validator", "", "configure the node as validator node: true|false")
+	pccsKey := configCmd.String("pccs-key", "", "intel pccs api key")
+	syncMode := configCmd.String("sync-mode", "", "chain sync mode: "+strings.Join(config.ChainSyncModes, "|"))
+	yes := configCmd.Bool("yes", false, "clean the chain data of an already configured node without asking")
+	from := configCmd.String("from", "", "yaml file with the answers")
+	if err = configCmd.Parse(args); err != nil {
+		return
+	}
+	if configCmd.NArg() > 0 {
+		err = fmt.Errorf("unknown config argument: %s", configCmd.Arg(0))
+		return
+	}
+	if *from != "" {
+		content, rerr := os.ReadFile(*from)
+		if rerr != nil {
+			err = fmt.Errorf("read answer file fail,err: %v", rerr)
+			return
+		}
+		if err = yaml.UnmarshalStrict(content, answers); err != nil {
+			err = fmt.Errorf("parse answer file %s fail,err: %v", *from, err)
+			return
+		}
+	}
+	if *validator != "" {
+		v, perr := strconv.ParseBool(*validator)
+		if perr != nil {
+			err = fmt.Errorf("invalid --validator value %s,use true or false", *validator)
+			return
+		}
+		answers.Validator = &v
+	}
+	if *pccsKey != "" {
+		answers.PccsKey = *pccsKey
+	}
+	if *syncMode != "" {
+		answers.SyncMode = *syncMode
+	}
+	if *yes {
+		answers.Yes = true
+	}
+	answers.unattended = configCmd.NFlag() > 0
+	return
+}
@@ -0,0 +1,172 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dcnetio/dc/config"
+	"github.com/dcnetio/dc/util"
+)
+
+var daemonLog = log.With("component", "daemon")
+
+// Take the daemon lock, so that one daemon runs per data dir. The lock is held until the process exits,
+// unlike the pid it records it can not be mistaken for another process reusing the pid.
+func acquireDaemonLock() (file *os.File, err error) {
+	file, err = os.OpenFile(daemonFilepath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	if err = lockFile(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("dcmanager daemon is already running")
+	}
+	file.Truncate(0)
+	file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	return
+}
+
+// Get the running status of dcmanager
+func checkDcDeamonStatusDc() (status bool, err error) {
+	file, err := os.Open(daemonFilepath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	defer file.Close()
+	return fileLocked(file), nil
+}
+
+// Name of the systemd unit running the daemon of the active profile
+func getDaemonUnitName() string {
+	if config.ActiveProfile != "" {
+		return "dc@" + config.ActiveProfile + ".service"
+	}
+	return "dc.service"
+}
+
+// Get the state of the systemd unit of the daemon,nil if dc is not run by systemd
+func getDaemonUnitStatus() *util.SystemdUnitStatus {
+	status, err := util.GetSystemdUnitStatus(getDaemonUnitName())
+	if err != nil {
+		return nil
+	}
+	return status
+}
+
+// Status text of the daemon shown by systemctl status and dc status
+var daemonStatus = struct {
+	sync.Mutex
+	text string
+}{}
+
+// Prefix of the status texts while the config has invalid values,set when the daemon starts
+var configInvalidStatus string
+
+// Check the config the daemon started with. An invalid value is reported by the status text and dc status
+// instead of stopping the daemon,the upgrades and restart loop watch keep running with the values as they are.
+func checkDaemonConfig() {
+	verr, ok := config.RunningConfig.Validate().(config.ValidationError)
+	if !ok {
+		return
+	}
+	daemonLog.Errorf("%v", verr)
+	configInvalidStatus = fmt.Sprintf("invalid config %s,fix it with dc config; ", strings.Join(verr.Fields(), ","))
+}
+
+// Tell systemd what the daemon is doing,repeated texts are not sent again
+func setDaemonStatus(format string, args ...interface{}) {
+	text := configInvalidStatus + fmt.Sprintf(format, args...)
+	daemonStatus.Lock()
+	defer daemonStatus.Unlock()
+	if text == daemonStatus.text {
+		return
+	}
+	daemonStatus.text = text
+	util.SdNotify("STATUS=" + text)
+}
+
+// Time the main loop of the daemon may take before it reports again,the watchdog pings stop once it is over
+const (
+	daemonIdleTimeout = 5 * time.Minute  //waiting for the next tick,the watch ticker wakes the loop every restartWatchInterval
+	daemonTickTimeout = 30 * time.Minute //an upgrade check,restart loop watch or upgrade step
+	fetchImageTimeout = 70 * time.Minute //the image download from the dc network may take up to an hour
+)
+
+// Deadline of the next report of the main loop
+var daemonHeartbeat = struct {
+	sync.Mutex
+	deadline time.Time
+}{}
+
+// Report that the main loop of the daemon is alive and will report again within timeout
+func daemonAlive(timeout time.Duration) {
+	daemonHeartbeat.Lock()
+	defer daemonHeartbeat.Unlock()
+	daemonHeartbeat.deadline = time.Now().Add(timeout)
+}
+
+// Whether the main loop of the daemon reported within the time it announced
+func daemonResponsive() bool {
+	daemonHeartbeat.Lock()
+	defer daemonHeartbeat.Unlock()
+	return time.Now().Before(daemonHeartbeat.deadline)
+}
+
+// Tell systemd the daemon is ready and send the watchdog pings while its main loop reports in time and its control api answers.
+// The pings are sent from their own goroutine,the main loop announces how long its current work may take,
+// so that a long upgrade step does not trip the watchdog but a wedged tick does.
+func notifyDaemonReady() {
+	if sent, err := util.SdNotify("READY=1"); err != nil {
+		daemonLog.Errorf("notify systemd fail,err: %v", err)
+	} else if sent {
+		daemonLog.Info("notified systemd the daemon is ready")
+	}
+	timeout := util.SdWatchdogTimeout()
+	if timeout <= 0 {
+		return
+	}
+	daemonLog.Infof("systemd watchdog is enabled,timeout %s", timeout)
+	go func() {
+		ticker := time.NewTicker(timeout / 3)
+		defer ticker.Stop()
+		for range ticker.C {
+			if !daemonResponsive() {
+				daemonLog.Errorf("main loop did not report in time,skip watchdog ping")
+				continue
+			}
+			if err := pingDaemonApi(timeout / 3); err != nil {
+				daemonLog.Errorf("control api does not answer,skip watchdog ping,err: %v", err)
+				continue
+			}
+			util.SdNotify("WATCHDOG=1")
+		}
+	}()
+}
+
+// Check that the control api of this daemon answers within timeout
+func pingDaemonApi(timeout time.Duration) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://dc/v1/upgrade", nil)
+	if err != nil {
+		return
+	}
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("control api answered %s", resp.Status)
+	}
+	return
+}
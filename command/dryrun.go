@@ -0,0 +1,141 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dcnetio/dc/blockchain"
+	"github.com/dcnetio/dc/util"
+)
+
+// Results of a dry-run check
+const (
+	checkPass = "pass"
+	checkFail = "fail"
+	checkSkip = "skip"
+)
+
+// One check of the upgrade dry run
+type DryRunCheck struct {
+	Name   string `json:"name" yaml:"name"`
+	Result string `json:"result" yaml:"result"` //pass,fail or skip
+	Detail string `json:"detail,omitempty" yaml:"detail,omitempty"`
+}
+
+// Report printed by "dc upgrade --dry-run"
+type DryRunReport struct {
+	TargetVersion string        `json:"targetVersion,omitempty" yaml:"targetVersion,omitempty"`
+	TargetSource  string        `json:"targetSource,omitempty" yaml:"targetSource,omitempty"`
+	UpgradeNeeded bool          `json:"upgradeNeeded" yaml:"upgradeNeeded"`
+	Passed        bool          `json:"passed" yaml:"passed"`
+	Checks        []DryRunCheck `json:"checks" yaml:"checks"`
+}
+
+func (r *DryRunReport) add(name string, result string, detail string) {
+	r.Checks = append(r.Checks, DryRunCheck{Name: name, Result: result, Detail: detail})
+	if result == checkFail {
+		r.Passed = false
+	}
+}
+
+// dc upgrade --dry-run: validate everything the upgrade needs without touching any container
+func upgradeDryRunCommandDeal() {
+	report := runUpgradeDryRun()
+	printDocument(report, func() {
+		if report.TargetVersion != "" {
+			fmt.Printf("target version(%s): %s,upgrade needed: %v\n", report.TargetSource, report.TargetVersion, report.UpgradeNeeded)
+		}
+		for _, check := range report.Checks {
+			fmt.Printf("[%s] %s: %s\n", check.Result, check.Name, check.Detail)
+		}
+		if report.Passed {
+			fmt.Println("dry run passed")
+		} else {
+			fmt.Println("dry run failed")
+		}
+	})
+}
+
+func runUpgradeDryRun() (report *DryRunReport) {
+	report = &DryRunReport{Passed: true}
+	//local dcstorage
+	version, enclaveId, err := getVersionByHttpGet(dcStorageListenPort)
+	if err != nil {
+		report.add("local dcstorage", checkFail, fmt.Sprintf("get local version fail,err: %v", err))
+	} else {
+		report.add("local dcstorage", checkPass, fmt.Sprintf("version: %s,enclaveid: %s", version, enclaveId))
+	}
+	//target program from chain or manual newVersion
+	check, err := checkUpgrade(version, enclaveId)
+	if check == nil || check.Target == nil {
+		report.add("resolve target program", checkFail, fmt.Sprintf("%v", err))
+		return
+	}
+	target := check.Target
+	report.TargetVersion = target.Version
+	report.TargetSource = check.TargetSource
+	report.UpgradeNeeded = check.UpgradeNeeded
+	if version == "" { //without a local version only the target program is resolved
+		report.add("resolve target program", checkSkip, fmt.Sprintf("target %s resolved,the local version could not be read,so whether the upgrade is needed is unknown", target.Version))
+	} else if err != nil {
+		report.add("resolve target program", checkFail, err.Error())
+	} else {
+		report.add("resolve target program", checkPass, check.Reason)
+	}
+	//committee signature on the enclaveid
+	if check.EnclaveIdValid {
+		report.add("enclaveid signature", checkPass, fmt.Sprintf("enclaveid %s is signed by the technical committee", target.EnclaveId))
+	} else {
+		report.add("enclaveid signature", checkFail, fmt.Sprintf("enclaveid %s is not signed by the technical committee", target.EnclaveId))
+	}
+	//image copies in the dc network
+	if len(target.MirrCids) == 0 {
+		report.add("dc network image", checkSkip, "no mirrCids configured")
+	}
+	for _, mCid := range target.MirrCids {
+		name := fmt.Sprintf("dc network image %s", mCid)
+		fileSize, addrInfos, err := blockchain.GetPeerAddrsForCid(mCid)
+		if err != nil {
+			report.add(name, checkFail, err.Error())
+		} else if len(addrInfos) == 0 {
+			report.add(name, checkFail, "no peer stores the image")
+		} else {
+			report.add(name, checkPass, fmt.Sprintf("size: %d,peers: %d", fileSize, len(addrInfos)))
+		}
+	}
+	//image manifests in the registries
+	cli, err := util.GetContainerRuntime()
+	for _, image := range []string{target.OriginUrl, target.MirrorUrl} {
+		name := fmt.Sprintf("registry image %s", image)
+		if image == "" {
+			continue
+		}
+		if err != nil {
+			report.add(name, checkFail, err.Error())
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		digest, merr := cli.ImageManifestDigest(ctx, image)
+		cancel()
+		if merr != nil {
+			report.add(name, checkFail, merr.Error())
+		} else {
+			report.add(name, checkPass, digest)
+		}
+	}
+	//upgrade assistant
+	if !util.IsSgx2Support() {
+		report.add("dcupgrade secretflag", checkSkip, "sgx2 not supported,dcupgrade is not used")
+	} else if !getContainerStatus(upgradeContainerName).Running {
+		report.add("dcupgrade secretflag", checkSkip, "dcupgrade is not running,it will be started by the upgrade")
+	} else {
+		dcSecretFlagUrl := fmt.Sprintf("http://%s:%d/secretflag", serverhost, dcUpgradeListenPort)
+		if _, err := util.HttpGet(dcSecretFlagUrl); err != nil {
+			report.add("dcupgrade secretflag", checkFail, err.Error())
+		} else {
+			report.add("dcupgrade secretflag", checkPass, dcSecretFlagUrl+" is reachable")
+		}
+	}
+	return
+}
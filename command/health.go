@@ -0,0 +1,116 @@
+package command
+
+import (
+	"context"
+	"time"
+
+	"github.com/dcnetio/dc/config"
+	"github.com/dcnetio/dc/util"
+)
+
+// A container restarted restartLoopCount times within restartLoopWindow is in a restart loop.
+// The daemon stops it and starts it again after a back off, which doubles on every loop up to restartBackoffMax
+// and is reset when the container has not restarted for restartLoopWindow.
+const (
+	restartLoopCount     = 5
+	restartLoopWindow    = 10 * time.Minute
+	restartBackoffMin    = time.Minute
+	restartBackoffMax    = time.Hour
+	restartWatchInterval = 30 * time.Second
+)
+
+// Services watched by the daemon for restart loops and failing healthchecks
+var watchedServices = []string{config.ServicePccs, config.ServiceChain, config.ServiceStorage, config.ServiceUpgrade}
+
+// Restart count of a container seen at a time
+type restartSample struct {
+	at    time.Time
+	count int
+}
+
+// Restarts and health of a container seen by the daemon
+type restartWatch struct {
+	samples     []restartSample //restart counts within restartLoopWindow
+	lastRestart time.Time
+	backoff     time.Duration
+	resumeAt    time.Time //when the container stopped for a restart loop is started again,zero if it is not stopped
+	health      string
+}
+
+var restartWatches = map[string]*restartWatch{}
+
+var watchLog = log.With("component", "watch")
+
+// Check the restart counts and health of the watched containers,called by the daemon every restartWatchInterval
+func watchRestartLoops(now time.Time) {
+	for _, service := range watchedServices {
+		containerName, _ := getServiceContainerName(service)
+		clog := watchLog.With("container", containerName)
+		w := restartWatches[service]
+		if w == nil {
+			w = &restartWatch{}
+			restartWatches[service] = w
+		}
+		if !w.resumeAt.IsZero() { //stopped for a restart loop
+			if now.Before(w.resumeAt) {
+				continue
+			}
+			w.resumeAt = time.Time{}
+			w.samples = nil
+			if service == config.ServiceStorage && !checkDcnodeCmdState() {
+				clog.Infof("dcstorage is stopped by command,not start it after the restart loop back off")
+				continue
+			}
+			clog.Infof("restart loop back off of %s is over,start it again", containerName)
+			recordEvent("alert", service, "restart loop back off is over,start it again")
+			if err := startServiceContainer(service, false); err != nil {
+				clog.Errorf("start %s after the restart loop back off fail,err: %v", containerName, err)
+			}
+			continue
+		}
+		status := getContainerStatus(containerName)
+		if status.State == "not created" {
+			w.samples = nil
+			continue
+		}
+		if status.Health != w.health {
+			if status.Health == "unhealthy" {
+				clog.Errorf("ALERT: %s is unhealthy,failing streak: %d,last healthcheck: %s", containerName, status.FailingStreak, status.LastCheck)
+				recordEvent("alert", service, "unhealthy,failing streak: %d,last healthcheck: %s", status.FailingStreak, status.LastCheck)
+			} else if w.health == "unhealthy" {
+				clog.Infof("%s is %s again", containerName, status.Health)
+				recordEvent("alert", service, "%s again", status.Health)
+			}
+			w.health = status.Health
+		}
+		if n := len(w.samples); n > 0 && status.RestartCount < w.samples[n-1].count { //recreated container,its count starts from 0
+			w.samples = nil
+		}
+		if n := len(w.samples); n > 0 && status.RestartCount > w.samples[n-1].count {
+			w.lastRestart = now
+		}
+		w.samples = append(w.samples, restartSample{at: now, count: status.RestartCount})
+		for len(w.samples) > 1 && now.Sub(w.samples[0].at) > restartLoopWindow {
+			w.samples = w.samples[1:]
+		}
+		restarts := status.RestartCount - w.samples[0].count
+		if restarts < restartLoopCount {
+			if w.backoff > 0 && now.Sub(w.lastRestart) > restartLoopWindow {
+				clog.Infof("%s has not restarted for %s,restart loop is over", containerName, restartLoopWindow)
+				w.backoff = 0
+				restartLoopGauge.WithLabelValues(containerName).Set(0)
+			}
+			continue
+		}
+		w.backoff = min(max(w.backoff*2, restartBackoffMin), restartBackoffMax)
+		w.resumeAt = now.Add(w.backoff)
+		restartLoopGauge.WithLabelValues(containerName).Set(1)
+		clog.Errorf("ALERT: %s is in a restart loop,restarted %d times in %s,last exit code: %d,oom killed: %v,stop it for %s",
+			containerName, restarts, now.Sub(w.samples[0].at).Round(time.Second), status.ExitCode, status.OOMKilled, w.backoff)
+		recordEvent("alert", service, "restart loop,restarted %d times,last exit code: %d,oom killed: %v,stopped for %s",
+			restarts, status.ExitCode, status.OOMKilled, w.backoff)
+		if err := util.StopContainer(context.Background(), containerName, 30); err != nil {
+			clog.Errorf("stop %s in restart loop fail,err: %v", containerName, err)
+		}
+	}
+}
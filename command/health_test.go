@@ -0,0 +1,104 @@
+package command
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dcnetio/dc/config"
+	"github.com/dcnetio/dc/util"
+)
+
+// One tick of the restart loop watch
+type watchTick struct {
+	after    time.Duration //time since the previous tick
+	restarts int           //restart count of the container at the tick
+	health   string
+}
+
+// Ticks every interval with the restart count growing by perTick
+func restartingTicks(n int, interval time.Duration, from int, perTick int) (ticks []watchTick) {
+	for i := 0; i < n; i++ {
+		ticks = append(ticks, watchTick{after: interval, restarts: from + i*perTick})
+	}
+	return
+}
+
+func TestWatchRestartLoops(t *testing.T) {
+	//5 restarts within 3 minutes,followed by the given ticks
+	loop := func(then ...watchTick) []watchTick {
+		return append(restartingTicks(6, restartWatchInterval, 0, 1), then...)
+	}
+	tests := []struct {
+		name        string
+		ticks       []watchTick
+		wantRunning bool
+		wantBackoff time.Duration
+		wantStopped bool //stopped for a restart loop,waiting for the back off
+		wantAlert   string
+	}{
+		{name: "stable", ticks: restartingTicks(10, restartWatchInterval, 2, 0), wantRunning: true},
+		{name: "restart loop", ticks: loop(), wantBackoff: restartBackoffMin, wantStopped: true, wantAlert: "restart loop,restarted 5 times"},
+		{name: "slow restarts", ticks: restartingTicks(20, 3*time.Minute, 0, 1), wantRunning: true},
+		{name: "recreated container", ticks: append(restartingTicks(4, restartWatchInterval, 0, 1), restartingTicks(4, restartWatchInterval, 0, 1)...), wantRunning: true},
+		{name: "back off over", ticks: loop(watchTick{after: restartBackoffMin, restarts: 5}), wantRunning: true, wantBackoff: restartBackoffMin},
+		{name: "back off doubles", ticks: loop(append([]watchTick{{after: restartBackoffMin}}, restartingTicks(6, restartWatchInterval, 1, 1)...)...), wantBackoff: 2 * restartBackoffMin, wantStopped: true},
+		{name: "back off reset", ticks: loop(append([]watchTick{{after: restartBackoffMin, restarts: 5}}, restartingTicks(25, restartWatchInterval, 5, 0)...)...), wantRunning: true},
+		{name: "unhealthy", ticks: []watchTick{{after: restartWatchInterval, health: "healthy"}, {after: restartWatchInterval, health: "unhealthy"}}, wantRunning: true, wantAlert: "unhealthy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := util.NewFakeRuntime()
+			util.SetContainerRuntime(fake)
+			restartWatches = map[string]*restartWatch{}
+			t.Cleanup(func() {
+				util.SetContainerRuntime(nil)
+				restartWatches = map[string]*restartWatch{}
+			})
+			spec, err := GetServiceSpec(config.ServicePccs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lastEvent := getLastTestEventId()
+			addTestContainer(t, fake, pccsContainerName, spec.Image, true)
+			now := time.Now()
+			for _, tick := range tt.ticks {
+				now = now.Add(tick.after)
+				c := fake.Container(pccsContainerName)
+				c.RestartCount = tick.restarts
+				c.Health = tick.health
+				watchRestartLoops(now)
+			}
+			c := fake.Container(pccsContainerName)
+			if c.Running != tt.wantRunning {
+				t.Errorf("running = %v,want %v", c.Running, tt.wantRunning)
+			}
+			w := restartWatches[config.ServicePccs]
+			if w.backoff != tt.wantBackoff {
+				t.Errorf("backoff = %s,want %s", w.backoff, tt.wantBackoff)
+			}
+			if stopped := !w.resumeAt.IsZero(); stopped != tt.wantStopped {
+				t.Errorf("stopped = %v,want %v", stopped, tt.wantStopped)
+			}
+			if tt.wantAlert != "" {
+				events, _ := getEventsAfter(lastEvent)
+				found := false
+				for _, event := range events {
+					found = found || (event.Kind == "alert" && strings.Contains(event.Message, tt.wantAlert))
+				}
+				if !found {
+					t.Errorf("no alert %q in events %v", tt.wantAlert, events)
+				}
+			}
+		})
+	}
+}
+
+// Get the id of the last recorded event,0 if there is none
+func getLastTestEventId() int64 {
+	events, _ := getEventsAfter(0)
+	if len(events) == 0 {
+		return 0
+	}
+	return events[len(events)-1].Id
+}
@@ -0,0 +1,249 @@
+package command
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/dcnetio/dc/util"
+	"github.com/docker/docker/api/types"
+	timetypes "github.com/docker/docker/api/types/time"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// Patterns of the log lines at or above a level,matching the go-log,substrate and logfmt/json formats of the services
+var logLevelPatterns = map[string]*regexp.Regexp{
+	"error": regexp.MustCompile(`\b(ERROR|FATAL|PANIC|CRIT|CRITICAL)\b|level=(error|fatal|panic)|"level":"(error|fatal|panic)"`),
+	"warn":  regexp.MustCompile(`\b(WARN|WARNING|ERROR|FATAL|PANIC|CRIT|CRITICAL)\b|level=(warn|warning|error|fatal|panic)|"level":"(warn|warning|error|fatal|panic)"`),
+}
+
+// Options of dc log
+type LogOptions struct {
+	Tail       int    //last lines to show,0 for all lines since the container started
+	Follow     bool   //keep printing new lines
+	Since      string //duration (1h) or timestamp
+	Until      string
+	Timestamps bool
+	Grep       *regexp.Regexp
+	Level      *regexp.Regexp
+	Export     string //gzip file to write the lines to instead of the terminal
+}
+
+// Print the real-time running log of a specific program
+// dc log {storage|chain|upgrade|pccs} [num] [--no-follow] [--since t] [--until t] [--timestamps] [--grep re] [--level error|warn] [--export file.gz]
+func LogCommandDeal() {
+	if len(os.Args) < 3 {
+		ShowHelp()
+		return
+	}
+	var containerName string
+	switch os.Args[2] {
+	case "storage":
+		containerName = nodeContainerName
+	case "chain":
+		containerName = chainContainerName
+	case "upgrade":
+		containerName = upgradeContainerName
+	case "pccs":
+		containerName = pccsContainerName
+	default:
+		ShowHelp()
+		return
+	}
+	options, err := parseLogOptions(os.Args[3:])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err = showContainerLogWithOptions(containerName, options); err != nil {
+		fmt.Fprintf(os.Stderr, "show logs error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// Parse the [num] and flags of dc log,num may be given before or after the flags
+func parseLogOptions(args []string) (options *LogOptions, err error) {
+	options = &LogOptions{Tail: 100}
+	fs := flag.NewFlagSet("log", flag.ContinueOnError)
+	noFollow := fs.Bool("no-follow", false, "print the lines and exit")
+	fs.StringVar(&options.Since, "since", "", "show lines since a duration (e.g. 1h) or timestamp")
+	fs.StringVar(&options.Until, "until", "", "show lines before a duration (e.g. 10m) or timestamp")
+	fs.BoolVar(&options.Timestamps, "timestamps", false, "show timestamps")
+	grep := fs.String("grep", "", "only show lines matching the regular expression")
+	level := fs.String("level", "", "only show lines at or above the level: error|warn")
+	fs.StringVar(&options.Export, "export", "", "write the lines to a gzip file")
+	tailSet := false
+	for {
+		if err = fs.Parse(args); err != nil {
+			return
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		if tailSet {
+			return nil, fmt.Errorf("unexpected argument: %s", fs.Arg(0))
+		}
+		if options.Tail, err = strconv.Atoi(fs.Arg(0)); err != nil || options.Tail < 0 {
+			return nil, fmt.Errorf("invalid number of lines: %s", fs.Arg(0))
+		}
+		tailSet = true
+		args = fs.Args()[1:]
+	}
+	if options.Since != "" && !tailSet { //all lines of the time range
+		options.Tail = 0
+	}
+	now := time.Now()
+	for _, value := range []*string{&options.Since, &options.Until} {
+		if *value == "" {
+			continue
+		}
+		if *value, err = timetypes.GetTimestamp(*value, now); err != nil {
+			return
+		}
+	}
+	if *grep != "" {
+		if options.Grep, err = regexp.Compile(*grep); err != nil {
+			return nil, fmt.Errorf("invalid --grep expression,err: %v", err)
+		}
+	}
+	if *level != "" {
+		if options.Level = logLevelPatterns[*level]; options.Level == nil {
+			return nil, fmt.Errorf("invalid --level %s,supported: error|warn", *level)
+		}
+	}
+	//A time range or an export ends,the terminal is only followed for live lines
+	options.Follow = !*noFollow && options.Until == "" && options.Export == ""
+	return
+}
+
+// show Container log
+func showContainerLog(containerName string, tnum int) {
+	err := showContainerLogWithOptions(containerName, &LogOptions{Tail: tnum, Follow: true})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "show logs error: %v\n", err)
+	}
+}
+
+// Print or export the log of the container,until the stream ends or the user interrupts it
+func showContainerLogWithOptions(containerName string, options *LogOptions) (err error) {
+	containerId, err := findContainerIdByName(containerName)
+	if err != nil {
+		return fmt.Errorf("find container id error: %v", err)
+	}
+	if containerId == "" {
+		return fmt.Errorf("container %s is not created", containerName)
+	}
+	cli, err := util.GetContainerRuntime()
+	if err != nil {
+		return
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	logsOptions := types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     options.Follow,
+		Since:      options.Since,
+		Until:      options.Until,
+		Timestamps: options.Timestamps,
+		Tail:       "all",
+	}
+	if options.Tail > 0 {
+		logsOptions.Tail = strconv.Itoa(options.Tail)
+	} else if options.Since == "" { //Print the latest log of the container running
+		execResp, ierr := cli.ContainerInspect(ctx, containerId)
+		if ierr != nil { //Container does not exist
+			return ierr
+		}
+		logsOptions.Since = execResp.State.StartedAt
+	}
+	reader, err := cli.ContainerLogs(ctx, containerId, logsOptions)
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+	var stdout, stderr io.Writer = os.Stdout, os.Stderr
+	var gz *gzip.Writer
+	if options.Export != "" {
+		file, ferr := os.OpenFile(options.Export, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if ferr != nil {
+			return ferr
+		}
+		defer file.Close()
+		gz = gzip.NewWriter(file)
+		stdout, stderr = gz, gz
+	}
+	outFilter := &lineFilterWriter{out: stdout, options: options}
+	errFilter := &lineFilterWriter{out: stderr, options: options}
+	//The containers run without tty,so stdout and stderr are multiplexed in the stream
+	_, err = stdcopy.StdCopy(outFilter, errFilter, reader)
+	if ctx.Err() != nil { //interrupted by the user
+		err = nil
+	}
+	outFilter.Flush()
+	errFilter.Flush()
+	if gz != nil {
+		if cerr := gz.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		if err == nil {
+			fmt.Printf("exported %d lines of %s to %s\n", outFilter.lines+errFilter.lines, containerName, options.Export)
+		}
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return
+}
+
+// lineFilterWriter writes the complete lines matching the grep and level of the options to out
+type lineFilterWriter struct {
+	out     io.Writer
+	options *LogOptions
+	partial []byte
+	lines   int
+}
+
+func (w *lineFilterWriter) Write(p []byte) (n int, err error) {
+	n = len(p)
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			return
+		}
+		if err = w.writeLine(w.partial[:i+1]); err != nil {
+			return
+		}
+		w.partial = w.partial[i+1:]
+	}
+}
+
+// Flush writes the last line if it does not end with a newline
+func (w *lineFilterWriter) Flush() {
+	if len(w.partial) > 0 {
+		w.writeLine(append(w.partial, '\n'))
+		w.partial = nil
+	}
+}
+
+func (w *lineFilterWriter) writeLine(line []byte) (err error) {
+	if w.options.Grep != nil && !w.options.Grep.Match(line) {
+		return
+	}
+	if w.options.Level != nil && !w.options.Level.Match(line) {
+		return
+	}
+	w.lines++
+	_, err = w.out.Write(line)
+	return
+}
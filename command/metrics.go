@@ -0,0 +1,175 @@
+package command
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dcnetio/dc/blockchain"
+	"github.com/dcnetio/dc/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsRefreshInterval = time.Minute
+
+// Metrics exported by the upgrade daemon on /metrics
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	serviceRunningGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dc_service_running",
+		Help: "Whether the managed service container is running (1) or not (0).",
+	}, []string{"service"})
+	serviceHealthyGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dc_service_healthy",
+		Help: "Whether the healthcheck of the managed service container passes (1) or not (0), absent without healthcheck.",
+	}, []string{"service"})
+	serviceRestartsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dc_service_restarts",
+		Help: "Number of times docker restarted the managed service container.",
+	}, []string{"service"})
+	restartLoopGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dc_service_restart_loop",
+		Help: "Whether the managed service container is in a restart loop (1) and stopped by the daemon for a back off.",
+	}, []string{"service"})
+	storageVersionGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dc_storage_version_info",
+		Help: "Version and enclave id reported by the locally running dcstorage, value is always 1.",
+	}, []string{"version", "enclave_id"})
+	chainProgramVersionGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dc_chain_program_version_info",
+		Help: "DcProgram version and enclave id configured on the blockchain, value is always 1.",
+	}, []string{"version", "enclave_id"})
+	chainUpGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "dc_chain_up",
+		Help: "Whether the chain node at chainWsUrl can be queried (1) or not (0).",
+	})
+	chainSyncingGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "dc_chain_syncing",
+		Help: "Whether the chain node is syncing (1) or synced (0), from System.Health.",
+	})
+	chainPeersGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "dc_chain_peers",
+		Help: "Number of peers connected to the chain node, from System.Health.",
+	})
+	onchainPeerNumberGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "dc_onchain_peer_number",
+		Help: "Number of dcstorage peers online on the blockchain (DcNode.OnchainPeerNumber).",
+	})
+	upgradeAttemptsCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "dc_upgrade_attempts_total",
+		Help: "Number of dcstorage upgrades started by the daemon.",
+	})
+	upgradeFailuresCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "dc_upgrade_failures_total",
+		Help: "Number of dcstorage upgrades that failed.",
+	})
+	lastUpgradeGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "dc_last_upgrade_timestamp_seconds",
+		Help: "Unix time of the last successful dcstorage upgrade.",
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		serviceRunningGauge,
+		serviceHealthyGauge,
+		serviceRestartsGauge,
+		restartLoopGauge,
+		storageVersionGauge,
+		chainProgramVersionGauge,
+		chainUpGauge,
+		chainSyncingGauge,
+		chainPeersGauge,
+		onchainPeerNumberGauge,
+		upgradeAttemptsCounter,
+		upgradeFailuresCounter,
+		lastUpgradeGauge,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Serve the prometheus metrics endpoint and keep the gauges refreshed, does nothing if metricsListenPort is 0
+func startMetricsServer() {
+	port := config.RunningConfig.MetricsListenPort
+	addr := net.JoinHostPort(config.RunningConfig.MetricsListenAddr, strconv.Itoa(port))
+	if port <= 0 {
+		log.Info("metrics endpoint is disabled")
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Infof("serve metrics on %s/metrics", addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Errorf("metrics server stopped,err: %v", err)
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(metricsRefreshInterval)
+		defer ticker.Stop()
+		for {
+			refreshMetrics()
+			<-ticker.C
+		}
+	}()
+}
+
+// Update the service,version and chain gauges
+func refreshMetrics() {
+	for _, containerName := range []string{nodeContainerName, chainContainerName, pccsContainerName, upgradeContainerName} {
+		status := getContainerStatus(containerName)
+		running := 0.0
+		if status.Running {
+			running = 1
+		}
+		serviceRunningGauge.WithLabelValues(containerName).Set(running)
+		serviceRestartsGauge.WithLabelValues(containerName).Set(float64(status.RestartCount))
+		switch status.Health {
+		case "healthy":
+			serviceHealthyGauge.WithLabelValues(containerName).Set(1)
+		case "unhealthy":
+			serviceHealthyGauge.WithLabelValues(containerName).Set(0)
+		default:
+			serviceHealthyGauge.DeleteLabelValues(containerName)
+		}
+	}
+	storageVersionGauge.Reset()
+	if version, enclaveId, err := getVersionByHttpGet(dcStorageListenPort); err == nil {
+		storageVersionGauge.WithLabelValues(version, enclaveId).Set(1)
+	}
+	health, err := blockchain.GetChainHealth()
+	if err != nil {
+		//Values read before the chain went down would look current,drop them
+		chainUpGauge.Set(0)
+		chainSyncingGauge.Set(0)
+		chainPeersGauge.Set(0)
+		chainProgramVersionGauge.Reset()
+		onchainPeerNumberGauge.Set(0)
+		return
+	}
+	chainUpGauge.Set(1)
+	if health.IsSyncing {
+		chainSyncingGauge.Set(1)
+	} else {
+		chainSyncingGauge.Set(0)
+	}
+	chainPeersGauge.Set(float64(health.Peers))
+	chainProgramVersionGauge.Reset()
+	if programInfo, err := blockchain.GetChainProgramInfo(); err == nil {
+		chainProgramVersionGauge.WithLabelValues(programInfo.Version, programInfo.EnclaveId).Set(1)
+	}
+	if num, err := blockchain.GetOnchainPeerNumber(context.Background()); err == nil {
+		onchainPeerNumberGauge.Set(float64(num))
+	}
+}
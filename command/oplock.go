@@ -0,0 +1,151 @@
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dcnetio/dc/config"
+	"golang.org/x/sys/unix"
+)
+
+var opLockFilepath string //Advisory lock held by the command or daemon operation that changes the containers
+
+// Holder of the operation lock, written to the lock file while it is held
+type OpLockHolder struct {
+	Pid       int       `json:"pid" yaml:"pid"`
+	Command   string    `json:"command" yaml:"command"`
+	Profile   string    `json:"profile,omitempty" yaml:"profile,omitempty"` //profile of the holder,empty for the default one
+	StartedAt time.Time `json:"startedAt" yaml:"startedAt"`
+}
+
+// opInProgressError is returned when another command or the daemon holds the operation lock
+type opInProgressError struct {
+	Holder *OpLockHolder
+}
+
+func (e *opInProgressError) Error() string {
+	if e.Holder == nil {
+		return "another operation is in progress"
+	}
+	if e.Holder.Profile != "" {
+		return fmt.Sprintf("operation %s of profile %s (pid %d) in progress since %s", e.Holder.Command, e.Holder.Profile, e.Holder.Pid, e.Holder.StartedAt.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("operation %s (pid %d) in progress since %s", e.Holder.Command, e.Holder.Pid, e.Holder.StartedAt.Format(time.RFC3339))
+}
+
+// opLock is the held operation lock, the kernel releases it when the process exits
+type opLock struct {
+	file   *os.File
+	holder OpLockHolder
+}
+
+// Take the operation lock for command without waiting, returns an opInProgressError if it is held.
+// Mutating commands (start,stop,restart,recreate,config,upgrade now) and the operations of the daemon take it,
+// so that a manual stop can not run in the middle of an upgrade and two starts do not race.
+// The lock is host wide: the commands of all profiles share it,as they share the pccs and tee report server containers.
+func acquireOpLock(command string) (lock *opLock, err error) {
+	file, err := os.OpenFile(opLockFilepath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	if err = lockFile(file); err != nil {
+		defer file.Close()
+		if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EACCES) {
+			return nil, &opInProgressError{Holder: readOpLockHolderFile(file)}
+		}
+		return nil, fmt.Errorf("lock %s fail,err: %v", opLockFilepath, err)
+	}
+	lock = &opLock{
+		file:   file,
+		holder: OpLockHolder{Pid: os.Getpid(), Command: command, Profile: config.ActiveProfile, StartedAt: time.Now()},
+	}
+	lock.writeHolder()
+	return
+}
+
+// Rename the operation, e.g. when the periodic upgrade check of the daemon starts an upgrade
+func (l *opLock) setCommand(command string) {
+	l.holder.Command = command
+	l.writeHolder()
+}
+
+func (l *opLock) writeHolder() {
+	content, _ := json.Marshal(&l.holder)
+	l.file.Truncate(0)
+	if _, err := l.file.WriteAt(content, 0); err != nil {
+		log.Errorf("write holder of %s fail,err: %v", opLockFilepath, err)
+	}
+}
+
+// Release the lock, the holder is cleared so that a stale file is not mistaken for a running operation
+func (l *opLock) release() {
+	if l == nil || l.file == nil {
+		return
+	}
+	l.file.Truncate(0)
+	l.file.Close() //closing the only descriptor of the open file releases its lock
+	l.file = nil
+}
+
+func readOpLockHolderFile(file *os.File) *OpLockHolder {
+	content, err := io.ReadAll(io.NewSectionReader(file, 0, 1<<16))
+	if err != nil || len(content) == 0 {
+		return nil
+	}
+	holder := &OpLockHolder{}
+	if err = json.Unmarshal(content, holder); err != nil {
+		return nil
+	}
+	return holder
+}
+
+// Get the holder of the operation lock, nil if it is not held
+func getOpLockHolder() *OpLockHolder {
+	file, err := os.Open(opLockFilepath)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	if !fileLocked(file) {
+		return nil
+	}
+	holder := readOpLockHolderFile(file)
+	if holder == nil {
+		holder = &OpLockHolder{Command: "unknown"}
+	}
+	return holder
+}
+
+// Take the exclusive lock of the whole file without waiting. It is an open file description lock:
+// like a flock it belongs to the open file and is released when it is closed,
+// unlike a flock it can be tested with F_OFD_GETLK without taking it,so a check never makes an acquire fail.
+func lockFile(file *os.File) error {
+	lk := unix.Flock_t{Type: unix.F_WRLCK, Whence: io.SeekStart}
+	return unix.FcntlFlock(file.Fd(), unix.F_OFD_SETLK, &lk)
+}
+
+// Whether another open file holds the lock of the file taken by lockFile
+func fileLocked(file *os.File) bool {
+	lk := unix.Flock_t{Type: unix.F_WRLCK, Whence: io.SeekStart}
+	if err := unix.FcntlFlock(file.Fd(), unix.F_OFD_GETLK, &lk); err != nil {
+		log.Errorf("test lock of %s fail,err: %v", file.Name(), err)
+		return false
+	}
+	return lk.Type != unix.F_UNLCK
+}
+
+// Take the operation lock for a command, printing the operation in progress and exiting if it is held
+func mustAcquireOpLock(command ...string) *opLock {
+	lock, err := acquireOpLock(strings.Join(command, " "))
+	if err != nil {
+		fmt.Println(err)
+		log.Error(err)
+		os.Exit(1)
+	}
+	return lock
+}
@@ -0,0 +1,54 @@
+package command
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// Checking whether the lock is held must never make an acquire fail,the daemon checks it on every watchdog ping
+func TestOpLockCheckDoesNotBlockAcquire(t *testing.T) {
+	saved := opLockFilepath
+	t.Cleanup(func() { opLockFilepath = saved })
+	opLockFilepath = filepath.Join(t.TempDir(), ".oplock")
+	if holder := getOpLockHolder(); holder != nil {
+		t.Fatalf("holder %+v without lock", holder)
+	}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					getOpLockHolder()
+				}
+			}
+		}()
+	}
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+	for i := 0; i < 2000; i++ {
+		lock, err := acquireOpLock("start")
+		if err != nil {
+			t.Fatalf("acquire %d fail while only checked,err: %v", i, err)
+		}
+		if holder := getOpLockHolder(); holder == nil || holder.Command != "start" || holder.Pid != os.Getpid() {
+			t.Fatalf("holder %+v,want start of pid %d", holder, os.Getpid())
+		}
+		if _, err = acquireOpLock("stop"); err == nil {
+			t.Fatal("second acquire of a held lock succeeded")
+		}
+		lock.release()
+	}
+	if holder := getOpLockHolder(); holder != nil {
+		t.Fatalf("holder %+v after release", holder)
+	}
+}
@@ -0,0 +1,212 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dcnetio/dc/blockchain"
+	"github.com/dcnetio/dc/config"
+	"github.com/dcnetio/dc/util"
+)
+
+const readinessPollInterval = 5 * time.Second
+
+// Services each service needs to be ready before it is started: pccs -> teereport -> dcchain -> dcstorage -> dcupgrade.
+// The chain is listed in full for every service,so that it is kept when the tee report server is disabled on sgx2 machines.
+var serviceDependencies = map[string][]string{
+	config.ServicePccs:      {},
+	config.ServiceTeeReport: {config.ServicePccs},
+	config.ServiceChain:     {config.ServicePccs, config.ServiceTeeReport},
+	config.ServiceStorage:   {config.ServicePccs, config.ServiceTeeReport, config.ServiceChain},
+	config.ServiceUpgrade:   {config.ServicePccs, config.ServiceTeeReport, config.ServiceChain, config.ServiceStorage},
+}
+
+// Services each service can not run without,started with a single service: dcchain runs on its own,
+// dcstorage needs the attestation of pccs and the tee report server and the chain,dcupgrade needs pccs
+var serviceRuntimeDependencies = map[string][]string{
+	config.ServicePccs:      {},
+	config.ServiceTeeReport: {config.ServicePccs},
+	config.ServiceChain:     {},
+	config.ServiceStorage:   {config.ServicePccs, config.ServiceTeeReport, config.ServiceChain},
+	config.ServiceUpgrade:   {config.ServicePccs},
+}
+
+// Results of a readiness probe
+const (
+	probeNotReady = iota
+	probeDegraded //usable by the services depending on it once the start timeout is over,e.g. a reachable but syncing dcchain
+	probeReady
+)
+
+// Whether the service is used on this host,the tee report server replaces dcupgrade on machines without sgx2
+func serviceEnabled(service string) bool {
+	switch service {
+	case config.ServiceTeeReport:
+		return !util.IsSgx2Support()
+	case config.ServiceUpgrade:
+		return util.IsSgx2Support()
+	}
+	return true
+}
+
+// Start the container of the service without its dependencies
+func startServiceOnly(service string) (err error) {
+	switch service {
+	case config.ServicePccs:
+		return runPccsInDocker()
+	case config.ServiceTeeReport:
+		return startTeeReportServerDocker()
+	case config.ServiceChain:
+		return startDcchainInDocker()
+	case config.ServiceStorage:
+		return startDcnodeInDocker()
+	case config.ServiceUpgrade:
+		return startDcupgradeInDocker()
+	}
+	return fmt.Errorf("unknown service: %s", service)
+}
+
+// Get the services to start for the given ones in dependency order,their dependencies in the graph included
+func startOrder(services []string, dependencies map[string][]string) (order []string) {
+	needed := map[string]bool{}
+	var need func(service string)
+	need = func(service string) {
+		if needed[service] || !serviceEnabled(service) {
+			return
+		}
+		needed[service] = true
+		for _, dep := range dependencies[service] {
+			need(dep)
+		}
+	}
+	for _, service := range services {
+		need(service)
+	}
+	//config.ServiceNames is in dependency order
+	for _, service := range config.ServiceNames {
+		if needed[service] {
+			order = append(order, service)
+		}
+	}
+	return
+}
+
+// Probe whether the service is ready to be used by the services depending on it
+func probeService(service string) (result int, detail string) {
+	switch service {
+	case config.ServicePccs:
+		if _, err := util.HttpGetWithoutCheckCert("https://localhost:8081/sgx/certification/v4/rootcacrl"); err != nil {
+			return probeNotReady, err.Error()
+		}
+	case config.ServiceChain:
+		health, err := blockchain.GetChainHealth()
+		if err != nil {
+			blockchain.ResetChainApi()
+			return probeNotReady, fmt.Sprintf("%s is not reachable", config.RunningConfig.ChainWsUrl)
+		}
+		if health.IsSyncing {
+			return probeDegraded, fmt.Sprintf("syncing,peers: %d", health.Peers)
+		}
+	case config.ServiceStorage:
+		if _, _, err := getVersionByHttpGet(dcStorageListenPort); err != nil {
+			return probeNotReady, err.Error()
+		}
+	case config.ServiceUpgrade:
+		if _, _, err := getVersionByHttpGet(dcUpgradeListenPort); err != nil {
+			return probeNotReady, err.Error()
+		}
+	default:
+		containerName, _ := getServiceContainerName(service)
+		if !getContainerStatus(containerName).Running {
+			return probeNotReady, "container is not running"
+		}
+	}
+	return probeReady, "ready"
+}
+
+// Get the start timeout of the service from its spec
+func getStartTimeout(service string) time.Duration {
+	spec, err := GetServiceSpec(service)
+	if err == nil {
+		if timeout, perr := time.ParseDuration(spec.StartTimeout); perr == nil {
+			return timeout
+		}
+	}
+	return defaultStartTimeouts[service]
+}
+
+// Wait until the service is ready or its start timeout is over
+func waitServiceReady(service string) (err error) {
+	containerName, _ := getServiceContainerName(service)
+	timeout := getStartTimeout(service)
+	fmt.Printf("wait for %s to be ready (timeout %s) ...\n", containerName, timeout)
+	log.Infof("wait for %s to be ready (timeout %s) ...", containerName, timeout)
+	deadline := time.Now().Add(timeout)
+	for {
+		result, detail := probeService(service)
+		if result == probeReady {
+			fmt.Printf("%s is ready\n", containerName)
+			log.Infof("%s is ready", containerName)
+			return
+		}
+		if status := getContainerStatus(containerName); status.State == "exited" || status.State == "dead" {
+			return fmt.Errorf("%s %s with exit code %d", containerName, status.State, status.ExitCode)
+		}
+		if time.Now().After(deadline) {
+			if result == probeDegraded {
+				fmt.Printf("%s is not ready after %s (%s),continue\n", containerName, timeout, detail)
+				log.Warnf("%s is not ready after %s (%s),continue", containerName, timeout, detail)
+				return
+			}
+			return fmt.Errorf("%s is not ready after %s: %s", containerName, timeout, detail)
+		}
+		time.Sleep(readinessPollInterval)
+	}
+}
+
+// Start the services and their dependencies in the graph in dependency order.
+// A service is only waited for when a later service depends on it,so that the requested services do not block on a syncing dcchain.
+func startServices(services []string, dependencies map[string][]string) (err error) {
+	order := startOrder(services, dependencies)
+	for i, service := range order {
+		if err = startServiceOnly(service); err != nil {
+			return fmt.Errorf("start %s fail,err: %v", service, err)
+		}
+		dependedOn := false
+		for _, later := range order[i+1:] {
+			for _, dep := range dependencies[later] {
+				dependedOn = dependedOn || dep == service
+			}
+		}
+		if !dependedOn {
+			continue
+		}
+		if err = waitServiceReady(service); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Stop the services in reverse dependency order,containers that are not created are skipped.
+// A failed stop does not keep the other services running,the errors of all services are returned
+func stopServices(services []string) (err error) {
+	var errs []error
+	for i := len(config.ServiceNames) - 1; i >= 0; i-- {
+		service := config.ServiceNames[i]
+		for _, s := range services {
+			if s != service {
+				continue
+			}
+			containerName, _ := getServiceContainerName(service)
+			if getContainerStatus(containerName).State == "not created" {
+				continue
+			}
+			if serr := stopService(service); serr != nil {
+				errs = append(errs, fmt.Errorf("stop %s fail,err: %v", service, serr))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,101 @@
+package command
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dcnetio/dc/config"
+	"github.com/dcnetio/dc/util"
+)
+
+// Keep the services enabled on this host,the tee report server and dcupgrade depend on sgx2
+func enabledServices(services ...string) (enabled []string) {
+	for _, service := range services {
+		if serviceEnabled(service) {
+			enabled = append(enabled, service)
+		}
+	}
+	return
+}
+
+func TestStartOrder(t *testing.T) {
+	var wantUpgrade []string //a disabled service is not started,nor are its dependencies
+	if serviceEnabled(config.ServiceUpgrade) {
+		wantUpgrade = enabledServices(config.ServicePccs, config.ServiceTeeReport, config.ServiceChain, config.ServiceStorage, config.ServiceUpgrade)
+	}
+	tests := []struct {
+		name         string
+		services     []string
+		dependencies map[string][]string
+		want         []string
+	}{
+		{name: "pccs", services: []string{config.ServicePccs}, dependencies: serviceDependencies, want: enabledServices(config.ServicePccs)},
+		{name: "chain", services: []string{config.ServiceChain}, dependencies: serviceDependencies, want: enabledServices(config.ServicePccs, config.ServiceTeeReport, config.ServiceChain)},
+		{name: "storage", services: []string{config.ServiceStorage}, dependencies: serviceDependencies, want: enabledServices(config.ServicePccs, config.ServiceTeeReport, config.ServiceChain, config.ServiceStorage)},
+		{name: "upgrade", services: []string{config.ServiceUpgrade}, dependencies: serviceDependencies, want: wantUpgrade},
+		{name: "reversed request", services: []string{config.ServiceStorage, config.ServiceChain, config.ServicePccs}, dependencies: serviceDependencies, want: enabledServices(config.ServicePccs, config.ServiceTeeReport, config.ServiceChain, config.ServiceStorage)},
+		{name: "duplicates", services: []string{config.ServiceTeeReport, config.ServiceTeeReport}, dependencies: serviceDependencies, want: enabledServices(config.ServicePccs, config.ServiceTeeReport)},
+		{name: "none", services: nil, dependencies: serviceDependencies, want: nil},
+		//a single service is started with its runtime dependencies only
+		{name: "single chain", services: []string{config.ServiceChain}, dependencies: serviceRuntimeDependencies, want: []string{config.ServiceChain}},
+		{name: "single storage", services: []string{config.ServiceStorage}, dependencies: serviceRuntimeDependencies, want: enabledServices(config.ServicePccs, config.ServiceTeeReport, config.ServiceChain, config.ServiceStorage)},
+		{name: "single pccs", services: []string{config.ServicePccs}, dependencies: serviceRuntimeDependencies, want: []string{config.ServicePccs}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := startOrder(tt.services, tt.dependencies); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("startOrder(%v) = %v,want %v", tt.services, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStopServicesByName(t *testing.T) {
+	savedCmdState := runCmdStateFilepath
+	t.Cleanup(func() {
+		runCmdStateFilepath = savedCmdState
+		util.SetContainerRuntime(nil)
+	})
+	runCmdStateFilepath = filepath.Join(t.TempDir(), ".cmdstate")
+	tests := []struct {
+		name      string
+		service   string
+		stopErr   error
+		wantErr   bool
+		wantStops int
+	}{
+		{name: "chain", service: "chain", wantStops: 1},
+		{name: "chain fails", service: "chain", stopErr: errors.New("timeout"), wantErr: true, wantStops: 1},
+		{name: "pccs fails", service: "pccs", stopErr: errors.New("timeout"), wantErr: true, wantStops: 1},
+		//a failed stop does not keep the other services running
+		{name: "all fails", service: "all", stopErr: errors.New("timeout"), wantErr: true, wantStops: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := util.NewFakeRuntime()
+			util.SetContainerRuntime(fake)
+			addTestContainer(t, fake, pccsContainerName, "dcnetio/pccs:latest", true)
+			addTestContainer(t, fake, chainContainerName, "dcnetio/dcchain:latest", true)
+			if tt.stopErr != nil {
+				fake.Errs["ContainerStop"] = tt.stopErr
+			}
+			fake.Calls = nil
+			err := stopServicesByName(tt.service)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("stopServicesByName(%s) err = %v,want error %v", tt.service, err, tt.wantErr)
+			}
+			stops := 0
+			for _, call := range fake.Calls {
+				if strings.HasPrefix(call, "ContainerStop ") {
+					stops++
+				}
+			}
+			if stops != tt.wantStops {
+				t.Errorf("stops = %d,want %d,calls: %v", stops, tt.wantStops, fake.Calls)
+			}
+		})
+	}
+}
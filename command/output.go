@@ -0,0 +1,302 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dcnetio/dc/config"
+	"github.com/dcnetio/dc/util"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Output formats supported by the global --output option
+const (
+	OutputText = "text"
+	OutputJson = "json"
+	OutputYaml = "yaml"
+)
+
+// OutputFormat is the format used by the query commands (status,uniqueid,peerinfo,memusage) to print results
+var OutputFormat = OutputText
+
+// --home given on the command line, passed on when dc runs itself
+var homeOption string
+
+// ParseGlobalOptions removes the global options (--output/-o,--profile,--home) from os.Args and applies them,
+// so that the command handlers keep seeing the "dc command [options]" layout.
+// The DC_HOME environment variable sets the home directory unless --home is given.
+func ParseGlobalOptions() (err error) {
+	args := []string{os.Args[0]}
+	home := os.Getenv("DC_HOME")
+	for i := 1; i < len(os.Args); i++ {
+		arg := os.Args[i]
+		switch {
+		case arg == "--output" || arg == "-o":
+			if i+1 >= len(os.Args) {
+				return fmt.Errorf("option %s needs a value: json|yaml|text", arg)
+			}
+			i++
+			OutputFormat = os.Args[i]
+		case strings.HasPrefix(arg, "--output="):
+			OutputFormat = strings.TrimPrefix(arg, "--output=")
+		case arg == "--profile":
+			if i+1 >= len(os.Args) {
+				return fmt.Errorf("option %s needs a profile name", arg)
+			}
+			i++
+			config.ActiveProfile = os.Args[i]
+		case strings.HasPrefix(arg, "--profile="):
+			config.ActiveProfile = strings.TrimPrefix(arg, "--profile=")
+		case arg == "--home":
+			if i+1 >= len(os.Args) {
+				return fmt.Errorf("option %s needs a directory", arg)
+			}
+			i++
+			homeOption = os.Args[i]
+			home = homeOption
+		case strings.HasPrefix(arg, "--home="):
+			homeOption = strings.TrimPrefix(arg, "--home=")
+			home = homeOption
+		default:
+			args = append(args, arg)
+		}
+	}
+	OutputFormat = strings.ToLower(OutputFormat)
+	if OutputFormat != OutputText && OutputFormat != OutputJson && OutputFormat != OutputYaml {
+		return fmt.Errorf("unsupported output format: %s, supported: json|yaml|text", OutputFormat)
+	}
+	if home != "" {
+		if err = config.SetHomeDir(home); err != nil {
+			return
+		}
+	}
+	os.Args = args
+	return
+}
+
+// GlobalArgs returns the global options that select the same home and profile,used when dc runs itself
+func GlobalArgs() (args []string) {
+	if homeOption != "" {
+		args = append(args, "--home", config.HomeDir)
+	}
+	if config.ActiveProfile != "" {
+		args = append(args, "--profile", config.ActiveProfile)
+	}
+	return
+}
+
+// printDocument prints doc in the selected structured format, or calls printText in text mode
+func printDocument(doc interface{}, printText func()) {
+	switch OutputFormat {
+	case OutputJson:
+		content, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "marshal output fail,err: %v\n", err)
+			return
+		}
+		fmt.Println(string(content))
+	case OutputYaml:
+		content, err := yaml.Marshal(doc)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "marshal output fail,err: %v\n", err)
+			return
+		}
+		fmt.Print(string(content))
+	default:
+		printText()
+	}
+}
+
+// Error of a query command,printed in place of its document in json/yaml mode
+type ErrorDocument struct {
+	Error string `json:"error" yaml:"error"`
+}
+
+// exitWithError reports that a query command failed and exits with status 1.
+// The message goes to stderr,in json/yaml mode an error document is printed on stdout too,so that scripts always get a document to parse.
+func exitWithError(message string) {
+	fmt.Fprintln(os.Stderr, message)
+	if OutputFormat != OutputText {
+		printDocument(&ErrorDocument{Error: message}, func() {})
+	}
+	os.Exit(1)
+}
+
+// Running information of a managed container
+type ContainerStatus struct {
+	Name          string               `json:"name" yaml:"name"`
+	State         string               `json:"state" yaml:"state"` //running,exited,... or "not created"
+	Running       bool                 `json:"running" yaml:"running"`
+	Image         string               `json:"image,omitempty" yaml:"image,omitempty"`
+	StartedAt     string               `json:"startedAt,omitempty" yaml:"startedAt,omitempty"`
+	UptimeSeconds int64                `json:"uptimeSeconds" yaml:"uptimeSeconds"`
+	RestartCount  int                  `json:"restartCount" yaml:"restartCount"`
+	Health        string               `json:"health,omitempty" yaml:"health,omitempty"` //starting,healthy or unhealthy,empty without healthcheck
+	ExitCode      int                  `json:"exitCode" yaml:"exitCode"`                 //exit code of the last run
+	OOMKilled     bool                 `json:"oomKilled" yaml:"oomKilled"`               //the last run was killed for running out of memory
+	FailingStreak int                  `json:"failingStreak,omitempty" yaml:"failingStreak,omitempty"`
+	LastCheck     string               `json:"lastCheck,omitempty" yaml:"lastCheck,omitempty"` //output of the last failed healthcheck
+	Limits        *config.ResourceSpec `json:"limits,omitempty" yaml:"limits,omitempty"`       //resource limits the container was created with
+}
+
+// Version information reported by dcstorage or dcupgrade
+type ProgramVersion struct {
+	Version   string `json:"version" yaml:"version"`
+	EnclaveId string `json:"enclaveId" yaml:"enclaveId"`
+}
+
+// Identity of the locally running dcstorage peer
+type PeerInfo struct {
+	PeerId        string `json:"peerId" yaml:"peerId"`
+	Pubkey        string `json:"pubkey" yaml:"pubkey"`
+	Account       string `json:"account" yaml:"account"`
+	WalletAddress string `json:"walletAddress" yaml:"walletAddress"`
+}
+
+// Document printed by "dc status" in json/yaml mode
+type StatusDocument struct {
+	Daemon        string                  `json:"daemon" yaml:"daemon"`
+	DaemonUnit    *util.SystemdUnitStatus `json:"daemonUnit,omitempty" yaml:"daemonUnit,omitempty"` //systemd unit of the daemon,absent without systemd
+	Services      []ContainerStatus       `json:"services" yaml:"services"`
+	Storage       *ProgramVersion         `json:"storage,omitempty" yaml:"storage,omitempty"`
+	Peer          *PeerInfo               `json:"peer,omitempty" yaml:"peer,omitempty"`
+	LastUpgrade   *UpgradeStatus          `json:"lastUpgrade,omitempty" yaml:"lastUpgrade,omitempty"`
+	Operation     *OpLockHolder           `json:"operation,omitempty" yaml:"operation,omitempty"`         //command or daemon operation holding the operation lock
+	InvalidConfig []string                `json:"invalidConfig,omitempty" yaml:"invalidConfig,omitempty"` //fields of the config with invalid values
+}
+
+// Result of the last dcstorage upgrade, read from the upgrade journal
+type UpgradeStatus struct {
+	TargetVersion string `json:"targetVersion" yaml:"targetVersion"`
+	State         string `json:"state" yaml:"state"` //upgrade step,or completed,aborted,rolled_back,failed
+	PreviousImage string `json:"previousImage" yaml:"previousImage"`
+	TargetImage   string `json:"targetImage,omitempty" yaml:"targetImage,omitempty"`
+	StartedAt     string `json:"startedAt" yaml:"startedAt"`
+	UpdatedAt     string `json:"updatedAt" yaml:"updatedAt"`
+	FailedStep    string `json:"failedStep,omitempty" yaml:"failedStep,omitempty"`
+	Error         string `json:"error,omitempty" yaml:"error,omitempty"`
+	RetryAt       string `json:"retryAt,omitempty" yaml:"retryAt,omitempty"` //end of the back off of the daemon after an aborted upgrade
+}
+
+func (u *UpgradeStatus) String() string {
+	str := fmt.Sprintf("version %s %s at %s", u.TargetVersion, u.State, u.UpdatedAt)
+	switch u.State {
+	case upgradeStepRolledBack:
+		str += fmt.Sprintf(", restored %s after failure at step %s: %s", u.PreviousImage, u.FailedStep, u.Error)
+	case upgradeStepAborted, upgradeStepFailed:
+		str += fmt.Sprintf(" at step %s: %s", u.FailedStep, u.Error)
+	}
+	if u.State == upgradeStepRolledBack || u.State == upgradeStepFailed {
+		str += fmt.Sprintf(" (the daemon does not retry version %s,run dc upgrade now to retry)", u.TargetVersion)
+	}
+	if u.RetryAt != "" {
+		str += fmt.Sprintf(" (the daemon retries version %s after %s)", u.TargetVersion, u.RetryAt)
+	}
+	return str
+}
+
+// Get the result of the last dcstorage upgrade, nil if no upgrade has been run
+func getLastUpgradeStatus() *UpgradeStatus {
+	j, err := readUpgradeJournal()
+	if err != nil || j == nil {
+		return nil
+	}
+	status := &UpgradeStatus{
+		TargetVersion: j.Program.Version,
+		State:         j.Step,
+		PreviousImage: j.PreviousImage,
+		TargetImage:   j.TargetImage,
+		StartedAt:     j.StartedAt.Format(time.RFC3339),
+		UpdatedAt:     j.UpdatedAt.Format(time.RFC3339),
+		FailedStep:    j.FailedStep,
+		Error:         j.Error,
+	}
+	if retryAt := j.abortedBackoffUntil(&j.Program); !retryAt.IsZero() {
+		status.RetryAt = retryAt.Format(time.RFC3339)
+	}
+	return status
+}
+
+// Document printed by "dc uniqueid" in json/yaml mode
+type UniqueIdDocument struct {
+	DcmanagerVersion string          `json:"dcmanagerVersion" yaml:"dcmanagerVersion"`
+	Storage          *ProgramVersion `json:"storage,omitempty" yaml:"storage,omitempty"`
+	Upgrade          *ProgramVersion `json:"upgrade,omitempty" yaml:"upgrade,omitempty"`
+}
+
+// Document printed by "dc memusage" in json/yaml mode
+type MemoryUsageDocument struct {
+	MemoryUsage string `json:"memoryUsage" yaml:"memoryUsage"`
+}
+
+// Get the running information of the container with the given name
+func getContainerStatus(containerName string) (status ContainerStatus) {
+	status = ContainerStatus{
+		Name:  containerName,
+		State: "not created",
+	}
+	cli, err := util.GetContainerRuntime()
+	if err != nil {
+		return
+	}
+	containerId, err := findContainerIdByName(containerName)
+	if err != nil || containerId == "" {
+		return
+	}
+	resp, err := cli.ContainerInspect(context.Background(), containerId)
+	if err != nil {
+		return
+	}
+	status.RestartCount = resp.RestartCount
+	if resp.HostConfig != nil {
+		status.Limits = getContainerLimits(&resp.HostConfig.Resources)
+	}
+	if resp.Config != nil {
+		status.Image = resp.Config.Image
+	}
+	if resp.State != nil {
+		status.State = resp.State.Status
+		status.Running = resp.State.Running
+		status.ExitCode = resp.State.ExitCode
+		status.OOMKilled = resp.State.OOMKilled
+		if health := resp.State.Health; health != nil {
+			status.Health = health.Status
+			status.FailingStreak = health.FailingStreak
+			if n := len(health.Log); n > 0 && health.Log[n-1].ExitCode != 0 {
+				status.LastCheck = strings.TrimSpace(health.Log[n-1].Output)
+			}
+		}
+		if resp.State.Running {
+			status.StartedAt = resp.State.StartedAt
+			if startedAt, perr := time.Parse(time.RFC3339Nano, resp.State.StartedAt); perr == nil {
+				status.UptimeSeconds = int64(time.Since(startedAt).Seconds())
+			}
+		}
+	}
+	return
+}
+
+// Print the health,restarts,last exit and limits of a container in text mode
+func printContainerDetails(cStatus ContainerStatus) {
+	if cStatus.State == "not created" {
+		return
+	}
+	details := fmt.Sprintf("  state: %s, restarts: %d, last exit code: %d", cStatus.State, cStatus.RestartCount, cStatus.ExitCode)
+	if cStatus.OOMKilled {
+		details += " (oom killed)"
+	}
+	if cStatus.Health != "" {
+		details += ", health: " + cStatus.Health
+	}
+	fmt.Println(details)
+	if cStatus.Health == "unhealthy" && cStatus.LastCheck != "" {
+		fmt.Println("  last healthcheck:", cStatus.LastCheck)
+	}
+	if cStatus.Limits != nil {
+		fmt.Println("  limits:", cStatus.Limits.String())
+	}
+}
@@ -0,0 +1,125 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dcnetio/dc/config"
+)
+
+var rolloutStateFilepath string //Record when the target dcstorage version was first seen and the rollout delay of this node for it
+
+// First time the daemon saw a target version, the rollout delay counts from it.
+// The delay is computed once per target, so that it does not change when the peer id can not be read later
+type rolloutState struct {
+	Version   string    `json:"version"`
+	EnclaveId string    `json:"enclaveId"`
+	FirstSeen time.Time `json:"firstSeen"`
+	Delay     *int64    `json:"delay,omitempty"` //seconds
+}
+
+// Get the time the target program was first seen and the rollout delay for it, recording them if the target is new
+func getRolloutSchedule(programInfo *config.DcProgram) (firstSeen time.Time, delay time.Duration) {
+	state := &rolloutState{}
+	if content, err := os.ReadFile(rolloutStateFilepath); err == nil {
+		json.Unmarshal(content, state)
+	}
+	if state.Version != programInfo.Version || state.EnclaveId != programInfo.EnclaveId || state.FirstSeen.IsZero() {
+		state = &rolloutState{
+			Version:   programInfo.Version,
+			EnclaveId: programInfo.EnclaveId,
+			FirstSeen: time.Now(),
+		}
+	}
+	if state.Delay == nil { //new target or a state file written without the delay
+		seconds := int64(getRolloutDelay() / time.Second)
+		state.Delay = &seconds
+		content, _ := json.Marshal(state)
+		if err := os.WriteFile(rolloutStateFilepath, content, 0644); err != nil {
+			log.Errorf("write rollout state fail,err: %v", err)
+		}
+	}
+	delay = time.Duration(*state.Delay) * time.Second
+	if delayMax := time.Duration(config.RunningConfig.UpgradePolicy.RolloutDelayMax) * time.Second; delay > delayMax { //rolloutDelayMax was lowered since
+		delay = max(delayMax, 0)
+	}
+	return state.FirstSeen, delay
+}
+
+// Rollout delay of this node, derived from its peer id so that it differs between nodes
+func getRolloutDelay() time.Duration {
+	delayMax := config.RunningConfig.UpgradePolicy.RolloutDelayMax
+	if delayMax <= 0 {
+		return 0
+	}
+	seed := config.RunningConfig.ChainNodeName
+	if peerid, _, _, err := getPeerInfoByHttpGet(); err == nil && peerid != "" {
+		seed = peerid
+	}
+	h := fnv.New64a()
+	h.Write([]byte(seed))
+	return time.Duration(h.Sum64()%uint64(delayMax+1)) * time.Second
+}
+
+// Whether t falls in one of the maintenance windows, no window means any time
+func inMaintenanceWindow(policy *config.UpgradePolicy, t time.Time) (bool, error) {
+	if len(policy.Windows) == 0 {
+		return true, nil
+	}
+	loc := time.Local
+	if policy.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(policy.Timezone); err != nil {
+			return false, fmt.Errorf("invalid upgrade policy timezone %s,err: %v", policy.Timezone, err)
+		}
+	}
+	t = t.In(loc)
+	for _, w := range policy.Windows {
+		day, hour := t.Weekday(), t.Hour()
+		if w.EndHour <= w.StartHour && hour < w.EndHour { //wrapped window,the early hours belong to the window started the day before
+			day = (day + 6) % 7
+			hour += 24
+		}
+		end := w.EndHour
+		if end <= w.StartHour {
+			end += 24
+		}
+		if hour < w.StartHour || hour >= end {
+			continue
+		}
+		if len(w.Days) == 0 {
+			return true, nil
+		}
+		for _, d := range w.Days {
+			if strings.EqualFold(d, day.String()[:3]) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// Decide whether the daemon may upgrade to the target program now, reason explains a refusal
+func checkUpgradePolicy(programInfo *config.DcProgram, now time.Time) (allowed bool, reason string) {
+	policy := &config.RunningConfig.UpgradePolicy
+	if policy.Hold {
+		return false, "upgrade is on hold by upgradePolicy.hold"
+	}
+	firstSeen, delay := getRolloutSchedule(programInfo)
+	upgradeAt := firstSeen.Add(delay)
+	if now.Before(upgradeAt) {
+		return false, fmt.Sprintf("rollout of version %s is delayed until %s", programInfo.Version, upgradeAt.Format(time.RFC3339))
+	}
+	inWindow, err := inMaintenanceWindow(policy, now)
+	if err != nil {
+		return false, err.Error()
+	}
+	if !inWindow {
+		return false, "outside of the maintenance windows"
+	}
+	return true, ""
+}
@@ -0,0 +1,42 @@
+package command
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dcnetio/dc/config"
+)
+
+func TestRolloutSchedule(t *testing.T) {
+	savedFilepath, savedPort, savedNodeName, savedDelayMax := rolloutStateFilepath, dcStorageListenPort, config.RunningConfig.ChainNodeName, config.RunningConfig.UpgradePolicy.RolloutDelayMax
+	t.Cleanup(func() {
+		rolloutStateFilepath, dcStorageListenPort, config.RunningConfig.ChainNodeName, config.RunningConfig.UpgradePolicy.RolloutDelayMax = savedFilepath, savedPort, savedNodeName, savedDelayMax
+	})
+	rolloutStateFilepath = filepath.Join(t.TempDir(), ".rolloutstate")
+	dcStorageListenPort = 1 //no peer id,the delay is derived from the chain node name
+	config.RunningConfig.ChainNodeName = "node1"
+	config.RunningConfig.UpgradePolicy.RolloutDelayMax = 86400
+	target := &config.DcProgram{Version: "1.2.0", EnclaveId: "enclave1"}
+
+	firstSeen, delay := getRolloutSchedule(target)
+	if delay != getRolloutDelay() {
+		t.Fatalf("delay %s,want %s", delay, getRolloutDelay())
+	}
+	//The delay is kept for the target when the seed changes
+	config.RunningConfig.ChainNodeName = "node2"
+	if seen, d := getRolloutSchedule(target); !seen.Equal(firstSeen) || d != delay {
+		t.Errorf("schedule %s+%s,want %s+%s", seen, d, firstSeen, delay)
+	}
+	//A lowered rolloutDelayMax caps the stored delay
+	config.RunningConfig.UpgradePolicy.RolloutDelayMax = 0
+	if _, d := getRolloutSchedule(target); d != 0 {
+		t.Errorf("delay %s with rolloutDelayMax 0", d)
+	}
+	//A new target gets a new first seen time and delay
+	config.RunningConfig.UpgradePolicy.RolloutDelayMax = 86400
+	time.Sleep(time.Millisecond)
+	if seen, d := getRolloutSchedule(&config.DcProgram{Version: "1.3.0", EnclaveId: "enclave2"}); !seen.After(firstSeen) || d != getRolloutDelay() {
+		t.Errorf("schedule %s+%s of a new target", seen, d)
+	}
+}
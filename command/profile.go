@@ -0,0 +1,64 @@
+package command
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dcnetio/dc/config"
+	"github.com/dcnetio/dc/util"
+)
+
+// SetupProfile applies the loaded config to the ports,container names,volumes and paths used by the commands.
+// With a profile active they are namespaced by its name, so that several networks can run on one host.
+func SetupProfile() (err error) {
+	c := config.RunningConfig
+	dcStorageListenPort = c.StorageListenPort
+	dcUpgradeListenPort = c.UpgradeListenPort
+	chainDataDir = c.GetChainDataDir()
+	dataDir := c.GetDataDir()
+	storageDisksDir = c.GetDisksDir()
+	storageEtcDir = c.GetStorageEtcDir()
+	if profile := config.ActiveProfile; profile != "" {
+		suffix := "-" + profile
+		nodeContainerName += suffix
+		chainContainerName += suffix
+		upgradeContainerName += suffix
+		nodeVolume += suffix
+		dataDir = c.GetProfileDataDir(profile)
+		storageDisksDir = c.GetProfileDisksDir(profile)
+		storageEtcDir = c.GetProfileStorageEtcDir(profile)
+	}
+	daemonFilepath = filepath.Join(dataDir, ".dcupgradedaemon")
+	runCmdStateFilepath = filepath.Join(dataDir, ".cmdstate")
+	upgradeJournalFilepath = filepath.Join(dataDir, ".upgradejournal")
+	rolloutStateFilepath = filepath.Join(dataDir, ".rolloutstate")
+	apiSocketFilepath = filepath.Join(dataDir, "dc.sock")
+	//One operation lock for the host,the pccs and tee report server containers are shared by all profiles
+	opLockFilepath = filepath.Join(config.HomeDir, ".oplock")
+	for _, dir := range []string{dataDir, storageDisksDir, storageEtcDir} {
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// SetupLogging applies the logging config to the log file of dc,the entries are labeled with the active profile
+func SetupLogging() error {
+	lc := config.RunningConfig.Logging
+	fileConfig := util.LogFileConfig{
+		Json:       lc.Format == "json",
+		Level:      lc.Level,
+		MaxSize:    int64(lc.MaxSize) * 1024 * 1024,
+		MaxBackups: lc.MaxBackups,
+		Compress:   lc.Compress,
+	}
+	if lc.MaxAge != "" {
+		fileConfig.MaxAge, _ = time.ParseDuration(lc.MaxAge)
+	}
+	if config.ActiveProfile != "" {
+		fileConfig.Labels = map[string]string{"profile": config.ActiveProfile}
+	}
+	return util.SetupLoggingConfig(config.RunningConfig.GetLogFile(), fileConfig)
+}
@@ -0,0 +1,205 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dcnetio/dc/config"
+	"github.com/dcnetio/dc/util"
+)
+
+// Get the container of the service and the function that creates and starts it
+func getServiceContainer(service string) (containerName string, start func() error, err error) {
+	if containerName, err = getServiceContainerName(service); err != nil {
+		return
+	}
+	switch service {
+	case config.ServiceStorage:
+		start = startDcStorageNode
+	case config.ServiceChain:
+		start = startDcChain
+	case config.ServicePccs:
+		start = runPccsInDocker
+	case config.ServiceUpgrade:
+		start = startDcupgradeInDocker
+	case config.ServiceTeeReport:
+		start = startTeeReportServerDocker
+	}
+	return
+}
+
+// Stop the container of the service with the stop timeout of the service
+func stopService(service string) (err error) {
+	switch service {
+	case config.ServiceStorage:
+		err = stopDcnodeInDocker()
+	case config.ServiceChain:
+		err = stopDcchainInDocker()
+	case config.ServicePccs:
+		err = stopPccsInDocker()
+	case config.ServiceUpgrade:
+		err = stopUpgradeInDocker()
+	case config.ServiceTeeReport:
+		err = util.StopContainer(context.Background(), teeReportServerContainerName, 10)
+	}
+	return
+}
+
+// Remove the container of the service so that it is created again from the current config,volumes are kept.
+// A running container is started again, a stopped one is only removed and picks up the config when it is started, unless startStopped is set.
+func recreateService(service string, startStopped bool) (err error) {
+	containerName, start, err := getServiceContainer(service)
+	if err != nil {
+		return
+	}
+	status := getContainerStatus(containerName)
+	if status.State == "not created" && !startStopped {
+		fmt.Printf("%s container is not created,the new config is used when it is started\n", containerName)
+		return
+	}
+	if status.Running {
+		fmt.Printf("stopping %s container ...\n", containerName)
+		log.Infof("stopping %s container ...", containerName)
+		if err = stopService(service); err != nil {
+			return
+		}
+	}
+	if status.State != "not created" {
+		fmt.Printf("removing %s container ...\n", containerName)
+		log.Infof("removing %s container ...", containerName)
+		if err = removeDockerContainer(containerName); err != nil {
+			return
+		}
+	}
+	if !status.Running && !startStopped {
+		return
+	}
+	if err = start(); err != nil {
+		return
+	}
+	if service == config.ServiceStorage {
+		setDcnodeCmdState("start")
+	}
+	fmt.Printf("%s container is recreated\n", containerName)
+	log.Infof("%s container is recreated", containerName)
+	return
+}
+
+// Stop the container of the service and start the same container again
+func restartService(service string) (err error) {
+	containerName, err := getServiceContainerName(service)
+	if err != nil {
+		return
+	}
+	cli, err := util.GetContainerRuntime()
+	if err != nil {
+		return
+	}
+	containerId, err := util.FindContainerIdByName(context.Background(), cli, containerName, true)
+	if err != nil {
+		return
+	}
+	if containerId == "" {
+		fmt.Printf("%s container is not created,use dc start to create it\n", containerName)
+		return
+	}
+	if err = stopService(service); err != nil {
+		return
+	}
+	fmt.Printf("starting %s  ...\n", containerName)
+	log.Infof("starting %s  ...", containerName)
+	if err = cli.ContainerStart(context.Background(), containerId); err != nil {
+		return
+	}
+	if service == config.ServiceStorage {
+		setDcnodeCmdState("start")
+	}
+	fmt.Printf("restart %s success\n", containerName)
+	log.Infof("restart %s success", containerName)
+	return
+}
+
+// Parse "{storage|chain|pccs|upgrade|all} [--yes]" of dc restart and dc recreate,all is the created containers in dependency order
+func parseServiceArgs(args []string) (services []string, yes bool, err error) {
+	for _, arg := range args {
+		switch arg {
+		case "--yes", "-y":
+			yes = true
+		case "all":
+			for _, service := range config.ServiceNames {
+				containerName, _ := getServiceContainerName(service)
+				if getContainerStatus(containerName).State != "not created" {
+					services = append(services, service)
+				}
+			}
+		case config.ServiceStorage, config.ServiceChain, config.ServicePccs, config.ServiceUpgrade, config.ServiceTeeReport:
+			services = append(services, arg)
+		default:
+			err = fmt.Errorf("unknown service: %s", arg)
+			return
+		}
+	}
+	if len(services) == 0 {
+		err = fmt.Errorf("no service given")
+	}
+	return
+}
+
+// dc restart {storage|chain|pccs|upgrade|all}
+func RestartCommandDeal() {
+	services, _, err := parseServiceArgs(os.Args[2:])
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println("usage: dc restart {storage|chain|pccs|upgrade|all}")
+		os.Exit(1)
+	}
+	if daemonApiAvailable() { //The daemon runs the restart,so that it does not interleave with an upgrade
+		if err = apiServicesCommand(services, "restart", false); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			log.Error(err)
+			os.Exit(1)
+		}
+		return
+	}
+	lock := mustAcquireOpLock("restart", strings.Join(services, " "))
+	defer lock.release()
+	for _, service := range services {
+		if err := restartService(service); err != nil {
+			fmt.Fprintf(os.Stderr, "restart %s service fail,err: %v\n", service, err)
+			log.Errorf("restart %s service fail,err: %v", service, err)
+			os.Exit(1)
+		}
+	}
+}
+
+// dc recreate {storage|chain|pccs|upgrade|all} [--yes]
+func RecreateCommandDeal() {
+	services, yes, err := parseServiceArgs(os.Args[2:])
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println("usage: dc recreate {storage|chain|pccs|upgrade|all} [--yes]")
+		os.Exit(1)
+	}
+	if !yes && !askForConfirm(fmt.Sprintf("%s will be stopped,removed and created again from the current config,volumes and data are kept,continue?(y/n): ", strings.Join(services, ","))) {
+		return
+	}
+	if daemonApiAvailable() { //The daemon recreates the containers from the config file,so that it does not interleave with an upgrade
+		if err = apiServicesCommand(services, "recreate", true); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			log.Error(err)
+			os.Exit(1)
+		}
+		return
+	}
+	lock := mustAcquireOpLock("recreate", strings.Join(services, " "))
+	defer lock.release()
+	for _, service := range services {
+		if err := recreateService(service, true); err != nil {
+			fmt.Fprintf(os.Stderr, "recreate %s service fail,err: %v\n", service, err)
+			log.Errorf("recreate %s service fail,err: %v", service, err)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dcnetio/dc/config"
+	"github.com/dcnetio/dc/util"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/go-connections/nat"
+	units "github.com/docker/go-units"
+)
+
+// Devices of the sgx driver, needed by the enclaves
+var sgxDevices = []string{"/dev/sgx/enclave", "/dev/sgx/provision"}
+
+// Log options of the services,the storage and chain logs are larger
+func jsonFileLog(maxSize string) config.LogSpec {
+	return config.LogSpec{
+		Driver: "json-file",
+		Options: map[string]string{
+			"max-size": maxSize,
+			"max-file": "3",
+		},
+	}
+}
+
+// Healthcheck getting url inside the container,curl or wget of the image is used
+func httpHealthcheck(url string, startPeriod string) *config.HealthSpec {
+	return &config.HealthSpec{
+		Test:        []string{"CMD-SHELL", fmt.Sprintf("curl -kfsS -o /dev/null %s || wget -q --no-check-certificate -O /dev/null %s", url, url)},
+		Interval:    "30s",
+		Timeout:     "10s",
+		StartPeriod: startPeriod,
+		Retries:     3,
+	}
+}
+
+// Healthcheck calling system_health over the rpc of dcchain,curl or wget of the image is used
+func chainHealthcheck(rpcPort int) *config.HealthSpec {
+	request := `{"id":1,"jsonrpc":"2.0","method":"system_health","params":[]}`
+	url := fmt.Sprintf("http://127.0.0.1:%d", rpcPort)
+	return &config.HealthSpec{
+		Test: []string{"CMD-SHELL", fmt.Sprintf("(curl -fsS -H 'Content-Type: application/json' -d '%s' %s || wget -qO- --header 'Content-Type: application/json' --post-data '%s' %s) | grep -q '\"peers\"'",
+			request, url, request, url)},
+		Interval:    "30s",
+		Timeout:     "10s",
+		StartPeriod: "120s",
+		Retries:     3,
+	}
+}
+
+// Time dc start waits for a service to be ready before starting the services depending on it
+var defaultStartTimeouts = map[string]time.Duration{
+	config.ServicePccs:      time.Minute,
+	config.ServiceTeeReport: 30 * time.Second,
+	config.ServiceChain:     5 * time.Minute,
+	config.ServiceStorage:   5 * time.Minute,
+	config.ServiceUpgrade:   time.Minute,
+}
+
+// Get the container name of the service
+func getServiceContainerName(service string) (containerName string, err error) {
+	switch service {
+	case config.ServiceStorage:
+		return nodeContainerName, nil
+	case config.ServiceChain:
+		return chainContainerName, nil
+	case config.ServicePccs:
+		return pccsContainerName, nil
+	case config.ServiceUpgrade:
+		return upgradeContainerName, nil
+	case config.ServiceTeeReport:
+		return teeReportServerContainerName, nil
+	}
+	return "", fmt.Errorf("unknown service: %s", service)
+}
+
+// Default container spec of the service, built from the config and the sgx support of the host
+func defaultServiceSpec(service string) (spec *config.ServiceSpec, err error) {
+	c := config.RunningConfig
+	switch service {
+	case config.ServiceStorage:
+		spec = &config.ServiceSpec{
+			Image:      c.NodeImage,
+			Entrypoint: []string{"dcstorage_native"}, //Run the enclave in non-sgx2 simulation state, and use plug-in programs for node authentication (the machine must be in an environment supervised by the committee to maximize the performance of the machine that does not support sgx2, and it is only for the sgx1 device in the original debugging environment before going online. All subsequent devices must be support sgx2)
+			Mounts: []config.MountSpec{
+				{Type: "volume", Source: nodeVolume, Target: "/opt/dcnetio/data"},
+				{Type: "bind", Source: storageDisksDir, Target: "/opt/dcnetio/disks", Propagation: string(mount.PropagationShared)},
+				{Type: "bind", Source: storageEtcDir, Target: "/opt/dcnetio/etc"},
+			},
+			Log:         jsonFileLog("100m"),
+			Healthcheck: httpHealthcheck(fmt.Sprintf("http://127.0.0.1:%d/version", dcStorageListenPort), "60s"),
+		}
+		//Determine whether sgx2 is supported
+		if util.IsSgx2Support() {
+			spec.Entrypoint = []string{"dcstorage"}
+		}
+		//Determine whether sgx is supported. In the early debugging stage, machines that do not support sgx can also run dcstorage.
+		if util.IsSgxSupport() {
+			spec.Devices = sgxDevices
+		}
+	case config.ServiceChain:
+		spec = &config.ServiceSpec{
+			Image:      c.ChainImage,
+			Entrypoint: dcchainEntrypoint(),
+			Mounts: []config.MountSpec{
+				{Type: "bind", Source: chainDataDir, Target: chainDataDir, Propagation: string(mount.PropagationShared)},
+			},
+			Log:         jsonFileLog("100m"),
+			Healthcheck: chainHealthcheck(c.ChainRpcListenPort),
+		}
+	case config.ServiceUpgrade:
+		spec = &config.ServiceSpec{
+			Image:   c.UpgradeImage,
+			Devices: sgxDevices,
+			Log:     jsonFileLog("10m"),
+		}
+	case config.ServiceTeeReport:
+		spec = &config.ServiceSpec{
+			Image:      c.TeeReportServerImage,
+			Entrypoint: []string{"dcteereportserver"},
+			Mounts: []config.MountSpec{
+				{Type: "volume", Source: teeReportServerVolume, Target: "/opt/dcnetio/dcteereportserver"},
+			},
+			Devices: sgxDevices,
+			Log:     jsonFileLog("10m"),
+		}
+	case config.ServicePccs:
+		spec = &config.ServiceSpec{
+			Image: c.PccsImage,
+			Env: []string{
+				"PCSURL=https://api.trustedservices.intel.com/sgx/certification/v4/",
+				"APIKEY=" + c.PccsKey,
+				"USERPASS=$Dcnetio_user0$", //default password
+				"ADMINPASS=$Dcnetio_admin0$",
+			},
+			Mounts: []config.MountSpec{
+				{Type: "volume", Source: pccsVolume, Target: "/opt/intel/pccs"},
+			},
+			Healthcheck: httpHealthcheck("https://127.0.0.1:8081/sgx/certification/v4/rootcacrl", "30s"),
+		}
+	default:
+		return nil, fmt.Errorf("unknown service: %s", service)
+	}
+	spec.NetworkMode = "host"
+	spec.RestartPolicy = "always"
+	spec.StartTimeout = defaultStartTimeouts[service].String()
+	return
+}
+
+// Arguments of dcchain built from the config
+func dcchainEntrypoint() (entrypoint []string) {
+	c := config.RunningConfig
+	entrypoint = append(entrypoint, "dcchain")
+	entrypoint = append(entrypoint, "--chain="+c.ChainSpec)
+	entrypoint = append(entrypoint, "--port", fmt.Sprint(c.ChainP2pPort))
+	entrypoint = append(entrypoint, "--rpc-port", fmt.Sprint(c.ChainRpcListenPort))
+	if len(c.ChainBootNode) > 20 {
+		entrypoint = append(entrypoint, "--bootnodes", c.ChainBootNode)
+	}
+	if c.ValidatorFlag == "enable" {
+		entrypoint = append(entrypoint, "--state-pruning", "archive")
+		entrypoint = append(entrypoint, "--blocks-pruning", "archive")
+		entrypoint = append(entrypoint, "--validator")
+	}
+	if c.ChainExposeFlag == "enable" {
+		entrypoint = append(entrypoint, "--unsafe-rpc-external")
+		entrypoint = append(entrypoint, "--rpc-cors", "all")
+	}
+	entrypoint = append(entrypoint, "-d", chainDataDir)
+	entrypoint = append(entrypoint, "--sync")
+	if c.ChainSyncMode != "" {
+		entrypoint = append(entrypoint, c.ChainSyncMode)
+	} else {
+		entrypoint = append(entrypoint, "full")
+	}
+	entrypoint = append(entrypoint, "--pool-limit", "819200")
+	entrypoint = append(entrypoint, "--pool-kbytes", "2048000")
+	entrypoint = append(entrypoint, "--rpc-max-subscriptions-per-connection", "1024000")
+	entrypoint = append(entrypoint, "--name", c.ChainNodeName)
+	return
+}
+
+// GetServiceSpec returns the spec the container of the service is created from: the default spec with the override of the config applied
+func GetServiceSpec(service string) (spec *config.ServiceSpec, err error) {
+	spec, err = defaultServiceSpec(service)
+	if err != nil {
+		return
+	}
+	spec.Merge(config.RunningConfig.Services[service])
+	return
+}
+
+// Convert a service spec to the docker container configs
+func buildContainerConfig(spec *config.ServiceSpec) (containerConfig *container.Config, hostConfig *container.HostConfig, err error) {
+	containerConfig = &container.Config{
+		Image: spec.Image,
+		Env:   spec.Env,
+	}
+	if len(spec.Entrypoint) > 0 || len(spec.ExtraArgs) > 0 {
+		containerConfig.Entrypoint = append(append([]string{}, spec.Entrypoint...), spec.ExtraArgs...)
+	}
+	hostConfig = &container.HostConfig{
+		NetworkMode: container.NetworkMode(spec.NetworkMode),
+	}
+	if spec.RestartPolicy != "" {
+		policy := strings.SplitN(spec.RestartPolicy, ":", 2)
+		hostConfig.RestartPolicy.Name = policy[0]
+		if len(policy) == 2 {
+			fmt.Sscan(policy[1], &hostConfig.RestartPolicy.MaximumRetryCount)
+		}
+	}
+	for _, m := range spec.Mounts {
+		dmount := mount.Mount{
+			Type:     mount.Type(m.Type),
+			Source:   m.Source,
+			Target:   m.Target,
+			ReadOnly: m.ReadOnly,
+		}
+		if m.Propagation != "" {
+			dmount.Consistency = mount.ConsistencyDefault
+			dmount.BindOptions = &mount.BindOptions{Propagation: mount.Propagation(m.Propagation)}
+		}
+		hostConfig.Mounts = append(hostConfig.Mounts, dmount)
+	}
+	for _, d := range spec.Devices {
+		parts := strings.SplitN(d, ":", 3)
+		device := container.DeviceMapping{PathOnHost: parts[0], PathInContainer: parts[0], CgroupPermissions: "rwm"}
+		if len(parts) > 1 {
+			device.PathInContainer = parts[1]
+		}
+		if len(parts) > 2 {
+			device.CgroupPermissions = parts[2]
+		}
+		hostConfig.Resources.Devices = append(hostConfig.Resources.Devices, device)
+	}
+	if spec.Resources.Memory != "" {
+		if hostConfig.Resources.Memory, err = units.RAMInBytes(spec.Resources.Memory); err != nil {
+			return
+		}
+	}
+	if spec.Resources.MemorySwap == "-1" {
+		hostConfig.Resources.MemorySwap = -1
+	} else if spec.Resources.MemorySwap != "" {
+		if hostConfig.Resources.MemorySwap, err = units.RAMInBytes(spec.Resources.MemorySwap); err != nil {
+			return
+		}
+	}
+	if spec.Resources.Cpus > 0 {
+		hostConfig.Resources.NanoCPUs = int64(spec.Resources.Cpus * 1e9)
+	}
+	hostConfig.Resources.CPUShares = spec.Resources.CpuShares
+	hostConfig.Resources.CPUQuota = spec.Resources.CpuQuota
+	hostConfig.Resources.CPUPeriod = spec.Resources.CpuPeriod
+	if spec.Resources.PidsLimit != 0 {
+		pidsLimit := spec.Resources.PidsLimit
+		hostConfig.Resources.PidsLimit = &pidsLimit
+	}
+	if nofile := spec.Resources.Nofile; nofile != nil {
+		hostConfig.Resources.Ulimits = append(hostConfig.Resources.Ulimits, &units.Ulimit{Name: "nofile", Soft: nofile.Soft, Hard: nofile.Hard})
+	}
+	if h := spec.Healthcheck; h != nil && len(h.Test) > 0 {
+		health := &container.HealthConfig{Test: h.Test, Retries: h.Retries}
+		health.Interval, _ = time.ParseDuration(h.Interval)
+		health.Timeout, _ = time.ParseDuration(h.Timeout)
+		health.StartPeriod, _ = time.ParseDuration(h.StartPeriod)
+		containerConfig.Healthcheck = health
+	}
+	if spec.Log.Driver != "" {
+		hostConfig.LogConfig = container.LogConfig{Type: spec.Log.Driver, Config: spec.Log.Options}
+	}
+	if len(spec.Ports) > 0 && !hostConfig.NetworkMode.IsHost() {
+		var exposedPorts nat.PortSet
+		exposedPorts, hostConfig.PortBindings, err = nat.ParsePortSpecs(spec.Ports)
+		if err != nil {
+			return
+		}
+		containerConfig.ExposedPorts = exposedPorts
+	}
+	return
+}
+
+// Create the container of the service from its spec and start it,
+// removeOldFlag: remove a container with the same name created from another image
+func startServiceContainer(service string, removeOldFlag bool) (err error) {
+	containerName, err := getServiceContainerName(service)
+	if err != nil {
+		return
+	}
+	spec, err := GetServiceSpec(service)
+	if err != nil {
+		return
+	}
+	ctx := context.Background()
+	for _, m := range spec.Mounts {
+		switch m.Type {
+		case "volume":
+			if _, err = util.CreateVolume(ctx, m.Source); err != nil {
+				return
+			}
+		case "bind":
+			if err = os.MkdirAll(m.Source, os.ModePerm); err != nil {
+				return
+			}
+		}
+	}
+	containerConfig, hostConfig, err := buildContainerConfig(spec)
+	if err != nil {
+		return
+	}
+	return util.StartContainer(ctx, containerName, removeOldFlag, containerConfig, hostConfig)
+}
+
+// Get the limits a container was created with, in the form of the service spec
+func getContainerLimits(resources *container.Resources) (limits *config.ResourceSpec) {
+	limits = &config.ResourceSpec{
+		CpuShares: resources.CPUShares,
+		CpuQuota:  resources.CPUQuota,
+		CpuPeriod: resources.CPUPeriod,
+	}
+	if resources.Memory > 0 {
+		limits.Memory = units.BytesSize(float64(resources.Memory))
+	}
+	if resources.MemorySwap == -1 {
+		limits.MemorySwap = "-1"
+	} else if resources.MemorySwap > 0 {
+		limits.MemorySwap = units.BytesSize(float64(resources.MemorySwap))
+	}
+	if resources.NanoCPUs > 0 {
+		limits.Cpus = float64(resources.NanoCPUs) / 1e9
+	}
+	if resources.PidsLimit != nil {
+		limits.PidsLimit = *resources.PidsLimit
+	}
+	for _, ulimit := range resources.Ulimits {
+		if ulimit.Name == "nofile" {
+			limits.Nofile = &config.UlimitSpec{Soft: ulimit.Soft, Hard: ulimit.Hard}
+		}
+	}
+	return
+}
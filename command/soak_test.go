@@ -0,0 +1,232 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dcnetio/dc/config"
+	"github.com/dcnetio/dc/util"
+	"github.com/docker/docker/api/types/container"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// newDockerApiServer serves the part of the docker engine api used by the upgrade from a FakeRuntime,
+// so that the real docker client,with its connections and goroutines,is exercised without a docker daemon
+func newDockerApiServer(t *testing.T, fake *util.FakeRuntime) *httptest.Server {
+	writeJson := func(w http.ResponseWriter, status int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+	writeErr := func(w http.ResponseWriter, err error) {
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "No such container") {
+			status = http.StatusNotFound
+		} else if strings.Contains(err.Error(), "Conflict") {
+			status = http.StatusConflict
+		}
+		writeJson(w, status, map[string]string{"message": err.Error()})
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Api-Version", "1.43")
+		w.Write([]byte("OK"))
+	})
+	mux.HandleFunc("POST /{version}/images/create", func(w http.ResponseWriter, r *http.Request) {
+		image := r.URL.Query().Get("fromImage")
+		if tag := r.URL.Query().Get("tag"); tag != "" {
+			image += ":" + tag
+		}
+		out, err := fake.ImagePull(r.Context(), image)
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+		out.Close()
+		writeJson(w, http.StatusOK, map[string]string{"status": "Downloaded newer image for " + image})
+	})
+	mux.HandleFunc("GET /{version}/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		all := r.URL.Query().Get("all") == "1"
+		containers, err := fake.ContainerList(r.Context(), all)
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+		writeJson(w, http.StatusOK, containers)
+	})
+	mux.HandleFunc("GET /{version}/containers/{id}/json", func(w http.ResponseWriter, r *http.Request) {
+		inspect, err := fake.ContainerInspect(r.Context(), r.PathValue("id"))
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+		writeJson(w, http.StatusOK, inspect)
+	})
+	mux.HandleFunc("POST /{version}/containers/create", func(w http.ResponseWriter, r *http.Request) {
+		body := struct {
+			*container.Config
+			HostConfig *container.HostConfig
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeErr(w, err)
+			return
+		}
+		id, err := fake.ContainerCreate(r.Context(), r.URL.Query().Get("name"), body.Config, body.HostConfig)
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+		writeJson(w, http.StatusCreated, container.CreateResponse{ID: id, Warnings: []string{}})
+	})
+	mux.HandleFunc("POST /{version}/containers/{id}/start", func(w http.ResponseWriter, r *http.Request) {
+		if err := fake.ContainerStart(r.Context(), r.PathValue("id")); err != nil {
+			writeErr(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /{version}/containers/{id}/stop", func(w http.ResponseWriter, r *http.Request) {
+		if err := fake.ContainerStop(r.Context(), r.PathValue("id"), nil); err != nil {
+			writeErr(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE /{version}/containers/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if err := fake.ContainerRemove(r.Context(), r.PathValue("id")); err != nil {
+			writeErr(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Steps of the simulated upgrade: the containers go through the shared runtime like a real upgrade,
+// the dc network,sgx secret handover,config file and version check are left out
+var soakStepActions = []func(j *UpgradeJournal) error{
+	func(j *UpgradeJournal) error { //fetch image
+		j.TargetImage = "dcnetio/dcstorage:" + j.Program.Version
+		return pullDcStorageNodeImage(j.TargetImage)
+	},
+	func(j *UpgradeJournal) error { //start helper
+		stopUpgradeInDocker()
+		return nil
+	},
+	func(j *UpgradeJournal) error { return nil }, //helper gets the secret
+	func(j *UpgradeJournal) error { //stop old
+		return util.StopContainer(context.Background(), nodeContainerName, 0)
+	},
+	removeOldDcstorage,
+	func(j *UpgradeJournal) error { //start new,without the mounts of the service on the host
+		config.RunningConfig.NodeImage = j.TargetImage
+		spec, err := GetServiceSpec(config.ServiceStorage)
+		if err != nil {
+			return err
+		}
+		containerConfig, hostConfig, err := buildContainerConfig(spec)
+		if err != nil {
+			return err
+		}
+		return util.StartContainer(context.Background(), nodeContainerName, true, containerConfig, hostConfig)
+	},
+	func(j *UpgradeJournal) error { return nil }, //new version gets the secret
+	func(j *UpgradeJournal) error { //save config,only in memory
+		config.RunningConfig.NodeImage = j.TargetImage
+		return nil
+	},
+	func(j *UpgradeJournal) error { //verify
+		if status := getContainerStatus(nodeContainerName); !status.Running || status.Image != j.TargetImage {
+			return fmt.Errorf("dcstorage %s is not running", j.TargetImage)
+		}
+		return nil
+	},
+}
+
+type soakSample struct {
+	heapAlloc  uint64
+	goroutines int
+}
+
+func takeSoakSample() soakSample {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return soakSample{heapAlloc: stats.HeapAlloc, goroutines: runtime.NumGoroutine()}
+}
+
+// The daemon runs upgrades in process for its whole life,so memory and goroutines must stay flat across upgrades.
+// The upgrades go through the docker client against a fake engine,a client created per operation and never closed
+// leaves its connections and their goroutines behind on every cycle.
+func TestUpgradeSoak(t *testing.T) {
+	if testing.Short() {
+		t.Skip("soak test")
+	}
+	const cycles = 200
+	const warmup = 20
+	logging.SetAllLoggers(logging.LevelError)
+	fake := util.NewFakeRuntime()
+	server := newDockerApiServer(t, fake)
+	t.Setenv("DOCKER_HOST", "tcp://"+strings.TrimPrefix(server.URL, "http://"))
+	util.CloseContainerRuntime()
+	t.Cleanup(func() { util.CloseContainerRuntime() })
+
+	savedJournalFilepath, savedNodeImage, savedActions, stdout := upgradeJournalFilepath, config.RunningConfig.NodeImage, upgradeStepActions, os.Stdout
+	t.Cleanup(func() {
+		os.Stdout = stdout
+		upgradeStepActions = savedActions
+		upgradeJournalFilepath = savedJournalFilepath
+		config.RunningConfig.NodeImage = savedNodeImage
+	})
+	upgradeJournalFilepath = filepath.Join(t.TempDir(), "upgrade.journal")
+	upgradeStepActions = soakStepActions
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { devNull.Close() })
+	os.Stdout = devNull //the container helpers print their progress
+
+	rt, err := util.GetContainerRuntime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var first soakSample
+	start := time.Now()
+	for i := 1; i <= cycles; i++ {
+		fake.Calls = nil //the fake records every call,the daemon keeps nothing per upgrade
+		journal := newUpgradeJournal(&config.DcProgram{Version: fmt.Sprintf("0.0.%d", i)})
+		if err = runUpgradeSteps(journal); err != nil {
+			t.Fatalf("simulated upgrade %d fail,err: %v", i, err)
+		}
+		if journal.Step != upgradeStepCompleted {
+			t.Fatalf("simulated upgrade %d ended at %s", i, journal.Step)
+		}
+		if i == warmup {
+			first = takeSoakSample()
+		}
+	}
+	last := takeSoakSample()
+	if shared, _ := util.GetContainerRuntime(); shared != rt {
+		t.Fatal("the upgrades replaced the shared container runtime")
+	}
+	t.Logf("%d upgrades in %s,heap %d -> %d bytes,goroutines %d -> %d", cycles, time.Since(start).Round(time.Millisecond), first.heapAlloc, last.heapAlloc, first.goroutines, last.goroutines)
+	//Allow for the noise of the gc,a leak grows with every cycle well beyond it
+	if heapLimit := first.heapAlloc + first.heapAlloc/2 + 4<<20; last.heapAlloc > heapLimit {
+		t.Errorf("heap grew from %d to %d bytes over %d upgrades,limit %d", first.heapAlloc, last.heapAlloc, cycles-warmup, heapLimit)
+	}
+	if last.goroutines > first.goroutines+2 {
+		t.Errorf("goroutines grew from %d to %d over %d upgrades", first.goroutines, last.goroutines, cycles-warmup)
+	}
+}
@@ -0,0 +1,202 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dcnetio/dc/blockchain"
+	"github.com/dcnetio/dc/config"
+	goversion "github.com/hashicorp/go-version"
+)
+
+// Sources of the program dcstorage should run
+const (
+	targetSourceChain  = "chain"  //DcProgram configured on the blockchain
+	targetSourceManual = "manual" //newVersion in the config file
+)
+
+// Result of comparing the local dcstorage with the program it should run,printed by "dc upgrade check"
+type UpgradeCheckDocument struct {
+	Local          ProgramVersion    `json:"local" yaml:"local"`
+	ChainProgram   *config.DcProgram `json:"chainProgram,omitempty" yaml:"chainProgram,omitempty"`
+	ManualProgram  *config.DcProgram `json:"manualProgram,omitempty" yaml:"manualProgram,omitempty"`
+	Target         *config.DcProgram `json:"target,omitempty" yaml:"target,omitempty"`
+	TargetSource   string            `json:"targetSource" yaml:"targetSource"`
+	EnclaveIdValid bool              `json:"enclaveIdValid" yaml:"enclaveIdValid"` //target enclaveid is signed by the technical committee
+	UpgradeNeeded  bool              `json:"upgradeNeeded" yaml:"upgradeNeeded"`
+	Reason         string            `json:"reason" yaml:"reason"`
+	DaemonPolicy   string            `json:"daemonPolicy,omitempty" yaml:"daemonPolicy,omitempty"` //when the upgrade daemon will apply the upgrade
+}
+
+// Decide the program dcstorage should run and whether the local version needs to be upgraded to it.
+// The program configured on the blockchain is used, unless newVersion in the config file is newer and has a valid enclaveid (manual upgrade).
+func checkUpgrade(version, enclaveId string) (check *UpgradeCheckDocument, err error) {
+	check = &UpgradeCheckDocument{
+		Local: ProgramVersion{Version: version, EnclaveId: enclaveId},
+	}
+	//Get the latest configured node enclaveid on the blockchain
+	programInfo, err := blockchain.GetConfigedDcStorageInfo()
+	if err != nil {
+		err = fmt.Errorf("get dcstorage version info from blockchain fail,err: %v", err)
+		return
+	}
+	check.ChainProgram = programInfo
+	check.Target = programInfo
+	check.TargetSource = targetSourceChain
+	bcVersion, err := goversion.NewVersion(programInfo.Version)
+	if err != nil {
+		err = fmt.Errorf("invalid new version format on blockchain,err: %v", err)
+		return
+	}
+	//Verify the enclaveid configured on the obtained chain
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if config.RunningConfig.NewVersion.Version != "" && config.RunningConfig.NewVersion.EnclaveId != "" { //If there is a new version number in the configuration file, use the version number in the configuration file (manual upgrade)
+		manualProgram := config.RunningConfig.NewVersion
+		check.ManualProgram = &manualProgram
+		configNewVersion, verr := goversion.NewVersion(manualProgram.Version)
+		if verr == nil && bcVersion.LessThan(configNewVersion) { //If the version number on the blockchain is smaller than the version number in the configuration file, use the version number in the configuration file (manual upgrade)
+			if blockchain.IfEnclaveIdValid(ctx, manualProgram.EnclaveId) {
+				check.Target = check.ManualProgram
+				check.TargetSource = targetSourceManual
+			}
+		}
+	}
+	check.EnclaveIdValid = blockchain.IfEnclaveIdValid(ctx, check.Target.EnclaveId)
+	if enclaveId == check.Target.EnclaveId {
+		check.Reason = "dcstorage is the latest version"
+		return
+	}
+	if !check.EnclaveIdValid {
+		check.Reason = fmt.Sprintf("enclaveid %s of version %s is not signed by the technical committee", check.Target.EnclaveId, check.Target.Version)
+		return
+	}
+	//Compare the old and new version numbers to determine whether an upgrade is needed
+	localVersion, err := goversion.NewVersion(version)
+	if err != nil {
+		err = fmt.Errorf("invalid local version format,err: %v", err)
+		return
+	}
+	configedVersion, err := goversion.NewVersion(check.Target.Version)
+	if err != nil {
+		err = fmt.Errorf("invalid new version format,err: %v", err)
+		return
+	}
+	if !localVersion.LessThan(configedVersion) { //The local version is updated, not updated
+		check.Reason = fmt.Sprintf("unneed upgrade ,dcstorage localVersion: %s,   configedVersion: %s", localVersion, configedVersion)
+		return
+	}
+	check.UpgradeNeeded = true
+	check.Reason = fmt.Sprintf("dcstorage can be upgraded from %s to %s", localVersion, configedVersion)
+	return
+}
+
+// Print the check result in text mode
+func printUpgradeCheck(check *UpgradeCheckDocument) {
+	fmt.Printf("local dcstorage version: %s,enclaveid: %s\n", check.Local.Version, check.Local.EnclaveId)
+	if check.ChainProgram != nil {
+		fmt.Printf("chain dcstorage version: %s,enclaveid: %s\n", check.ChainProgram.Version, check.ChainProgram.EnclaveId)
+	}
+	if check.ManualProgram != nil {
+		fmt.Printf("config newVersion: %s,enclaveid: %s\n", check.ManualProgram.Version, check.ManualProgram.EnclaveId)
+	}
+	if check.Target != nil {
+		fmt.Printf("target version(%s): %s,enclaveid: %s,enclaveid valid: %v\n", check.TargetSource, check.Target.Version, check.Target.EnclaveId, check.EnclaveIdValid)
+		fmt.Printf("target image: %s (mirror: %s)\n", check.Target.OriginUrl, check.Target.MirrorUrl)
+	}
+	fmt.Println(check.Reason)
+	if check.DaemonPolicy != "" {
+		fmt.Println("upgrade daemon:", check.DaemonPolicy)
+	}
+}
+
+// Get the local dcstorage version and compare it with the program it should run
+func getUpgradeCheck() (check *UpgradeCheckDocument, err error) {
+	version, enclaveId, err := getVersionByHttpGet(dcStorageListenPort)
+	if err != nil {
+		err = fmt.Errorf("get local dcstorage version fail,please make sure storage service is running")
+		return
+	}
+	fmt.Fprintln(os.Stderr, "wait for blockchain syncing complete...")
+	check, err = checkUpgrade(version, enclaveId)
+	if err == nil && check.UpgradeNeeded {
+		if allowed, reason := checkUpgradePolicy(check.Target, time.Now()); allowed {
+			check.DaemonPolicy = "upgrade allowed now"
+		} else {
+			check.DaemonPolicy = reason
+		}
+	}
+	return
+}
+
+// dc upgrade check
+func upgradeCheckCommandDeal() {
+	check, err := getUpgradeCheck()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printDocument(check, func() {
+		printUpgradeCheck(check)
+	})
+}
+
+// dc upgrade now [--yes]
+func upgradeNowCommandDeal(yes bool) {
+	check, err := getUpgradeCheck()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printUpgradeCheck(check)
+	if !check.UpgradeNeeded {
+		return
+	}
+	if config.RunningConfig.UpgradePolicy.Hold {
+		fmt.Println("warning: upgradePolicy.hold is set, the manual upgrade ignores it")
+	}
+	if !yes && !askForConfirm(fmt.Sprintf("dcstorage will be stopped during the upgrade, upgrade to %s now?(y/n): ", check.Target.Version)) {
+		return
+	}
+	fmt.Printf("upgrading dcstorage to version %s ...\n", check.Target.Version)
+	if daemonApiAvailable() { //The daemon runs the upgrade,so that it does not interleave with its own upgrade or a stop
+		err = apiUpgrade(check.Target)
+	} else {
+		lock := mustAcquireOpLock("upgrade now")
+		err = performUpgrade(check.Target)
+		lock.release()
+	}
+	if lastUpgrade := getLastUpgradeStatus(); lastUpgrade != nil {
+		fmt.Println("upgrade result:", lastUpgrade.String())
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "upgrade dcstorage fail,err: %v\n", err)
+		return
+	}
+	fmt.Printf("upgrade dcstorage to version %s success\n", check.Target.Version)
+}
+
+// Ask the user a y/n question, returns true if the answer is y
+func askForConfirm(prompt string) bool {
+	fmt.Print(prompt)
+	var input string
+	for {
+		input = ""
+		if _, err := fmt.Scanln(&input); err == io.EOF { //no terminal,take it as a refusal
+			fmt.Println()
+			return false
+		}
+		input = strings.ToLower(input)
+		if input != "y" && input != "n" {
+			fmt.Print("please input y or n : ")
+			continue
+		} else {
+			break
+		}
+	}
+	return input == "y"
+}
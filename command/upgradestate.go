@@ -0,0 +1,479 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dcnetio/dc/blockchain"
+	"github.com/dcnetio/dc/config"
+	"github.com/dcnetio/dc/util"
+	"go.uber.org/zap"
+)
+
+var upgradeJournalFilepath string //Record the progress of the dcstorage upgrade,used to resume after a restart
+
+// Steps of the dcstorage upgrade state machine, in execution order
+const (
+	upgradeStepStarted          = "started"            //target program resolved
+	upgradeStepImageReady       = "image_ready"        //new image loaded from dc network or pulled from registry
+	upgradeStepHelperStarted    = "helper_started"     //dcupgrade restarted to receive the peer secret
+	upgradeStepSecretHandedOver = "secret_handed_over" //dcupgrade got the peer secret from the old dcstorage
+	upgradeStepOldStopped       = "old_stopped"        //old dcstorage stopped
+	upgradeStepOldRemoved       = "old_removed"        //old dcstorage container removed
+	upgradeStepNewStarted       = "new_started"        //new dcstorage container started
+	upgradeStepNewSecretReady   = "new_secret_ready"   //new dcstorage got the peer secret from dcupgrade
+	upgradeStepConfigSaved      = "config_saved"       //new nodeImage saved to config
+	upgradeStepCompleted        = "completed"          //new version verified
+)
+
+// Terminal states of an upgrade
+const (
+	upgradeStepAborted    = "aborted"     //stopped before the old dcstorage was removed, old dcstorage restored
+	upgradeStepRolledBack = "rolled_back" //failed after the old dcstorage was removed, old dcstorage recreated and config restored
+	upgradeStepFailed     = "failed"      //failed and the old dcstorage could not be restored
+)
+
+// Back off of the daemon before it retries a target whose upgrade was aborted,doubled on every abort in a row
+const (
+	abortedRetryBackoff    = 10 * time.Minute
+	abortedRetryBackoffMax = 24 * time.Hour
+)
+
+const crashLoopRestartCount = 3 //The new dcstorage is considered crash looping once docker restarted it this many times
+
+var upgradeStepOrder = []string{
+	upgradeStepStarted,
+	upgradeStepImageReady,
+	upgradeStepHelperStarted,
+	upgradeStepSecretHandedOver,
+	upgradeStepOldStopped,
+	upgradeStepOldRemoved,
+	upgradeStepNewStarted,
+	upgradeStepNewSecretReady,
+	upgradeStepConfigSaved,
+	upgradeStepCompleted,
+}
+
+// upgradeStepActions[i] moves the upgrade from upgradeStepOrder[i] to upgradeStepOrder[i+1]
+var upgradeStepActions = []func(j *UpgradeJournal) error{
+	fetchUpgradeImage,
+	startUpgradeHelper,
+	waitHelperGetPeerSecret,
+	stopOldDcstorage,
+	removeOldDcstorage,
+	startNewDcstorage,
+	waitNewDcstorageGetPeerSecret,
+	saveUpgradeConfig,
+	verifyNewDcstorage,
+}
+
+// Start the previous dcstorage when an upgrade is aborted or rolled back
+var restoreDcstorage = startDcStorageNode
+
+// One state change of the upgrade
+type UpgradeTransition struct {
+	Step string    `json:"step"`
+	At   time.Time `json:"at"`
+}
+
+// UpgradeJournal is the persisted progress of a dcstorage upgrade
+type UpgradeJournal struct {
+	Step          string              `json:"step"`
+	Program       config.DcProgram    `json:"program"`       //target program
+	PreviousImage string              `json:"previousImage"` //dcstorage image before the upgrade
+	TargetImage   string              `json:"targetImage"`   //dcstorage image of the target program
+	StartedAt     time.Time           `json:"startedAt"`
+	UpdatedAt     time.Time           `json:"updatedAt"`
+	FailedStep    string              `json:"failedStep,omitempty"` //last step reached before the upgrade failed or was aborted
+	Error         string              `json:"error,omitempty"`
+	Aborts        int                 `json:"aborts,omitempty"` //upgrades to the program aborted in a row
+	History       []UpgradeTransition `json:"history"`
+}
+
+func newUpgradeJournal(programInfo *config.DcProgram) *UpgradeJournal {
+	now := time.Now()
+	return &UpgradeJournal{
+		Step:          upgradeStepStarted,
+		Program:       *programInfo,
+		PreviousImage: config.RunningConfig.NodeImage,
+		StartedAt:     now,
+		UpdatedAt:     now,
+		History:       []UpgradeTransition{{Step: upgradeStepStarted, At: now}},
+	}
+}
+
+// Read the upgrade journal, returns nil if no upgrade has been journaled
+func readUpgradeJournal() (j *UpgradeJournal, err error) {
+	content, err := os.ReadFile(upgradeJournalFilepath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	j = &UpgradeJournal{}
+	if err = json.Unmarshal(content, j); err != nil {
+		j = nil
+	}
+	return
+}
+
+// Write the journal to disk atomically
+func (j *UpgradeJournal) save() (err error) {
+	content, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(upgradeJournalFilepath), 0755); err != nil {
+		return
+	}
+	tmpPath := upgradeJournalFilepath + ".tmp"
+	if err = os.WriteFile(tmpPath, content, 0644); err != nil {
+		return
+	}
+	return os.Rename(tmpPath, upgradeJournalFilepath)
+}
+
+// Logger of the upgrade,the entries carry the current step and target version as fields in the json log format
+func (j *UpgradeJournal) logger() *zap.SugaredLogger {
+	return log.With("component", "upgrade", "step", j.Step, "version", j.Program.Version)
+}
+
+// Move the upgrade to the given step and persist it
+func (j *UpgradeJournal) transition(step string) (err error) {
+	now := time.Now()
+	j.logger().Infof("dcstorage upgrade step: %s -> %s", j.Step, step)
+	recordEvent("upgrade", config.ServiceStorage, "upgrade to version %s: %s -> %s", j.Program.Version, j.Step, step)
+	setDaemonStatus("upgrade dcstorage to version %s: %s", j.Program.Version, step)
+	fmt.Printf("[%s] upgrade step: %s\n", now.Format("15:04:05"), step)
+	j.Step = step
+	j.UpdatedAt = now
+	j.History = append(j.History, UpgradeTransition{Step: step, At: now})
+	if err = j.save(); err != nil {
+		j.logger().Errorf("save upgrade journal fail,err: %v", err)
+	}
+	return
+}
+
+// Whether the upgrade has finished, successfully or not
+func (j *UpgradeJournal) terminal() bool {
+	return j.Step == upgradeStepCompleted || j.Step == upgradeStepAborted || j.Step == upgradeStepRolledBack || j.Step == upgradeStepFailed
+}
+
+// Whether the journal records a rolled back or failed upgrade to the program.
+// The daemon does not retry such a target until the target on the chain changes or an operator runs dc upgrade now.
+func (j *UpgradeJournal) failedFor(programInfo *config.DcProgram) bool {
+	if j == nil || programInfo == nil {
+		return false
+	}
+	if j.Step != upgradeStepRolledBack && j.Step != upgradeStepFailed {
+		return false
+	}
+	return j.forProgram(programInfo)
+}
+
+// Get the time until which the daemon backs off from the program after the journal recorded an aborted upgrade to it,
+// zero if the last upgrade to it was not aborted
+func (j *UpgradeJournal) abortedBackoffUntil(programInfo *config.DcProgram) time.Time {
+	if j == nil || programInfo == nil || j.Step != upgradeStepAborted || !j.forProgram(programInfo) {
+		return time.Time{}
+	}
+	backoff := abortedRetryBackoff
+	for i := 1; i < j.Aborts && backoff < abortedRetryBackoffMax; i++ {
+		backoff *= 2
+	}
+	return j.UpdatedAt.Add(min(backoff, abortedRetryBackoffMax))
+}
+
+// Whether the journal is of an upgrade to the program
+func (j *UpgradeJournal) forProgram(programInfo *config.DcProgram) bool {
+	return j.Program.Version == programInfo.Version && j.Program.EnclaveId == programInfo.EnclaveId
+}
+
+// Whether the old dcstorage container may already be gone, from then on the upgrade can only move forward
+func (j *UpgradeJournal) pastOldRemoval() bool {
+	return upgradeStepIndex(j.Step) >= upgradeStepIndex(upgradeStepOldRemoved)
+}
+
+func upgradeStepIndex(step string) int {
+	for i, s := range upgradeStepOrder {
+		if s == step {
+			return i
+		}
+	}
+	return -1
+}
+
+// Run the upgrade state machine from the current step of the journal until it finishes
+func runUpgradeSteps(j *UpgradeJournal) (err error) {
+	if j.pastOldRemoval() { //The new image must be used for every later step,including a resumed one
+		config.RunningConfig.NodeImage = j.TargetImage
+	}
+	for idx := upgradeStepIndex(j.Step); idx >= 0 && idx < len(upgradeStepOrder)-1; idx++ {
+		if upgradeStepOrder[idx] == upgradeStepStarted { //keep the watchdog of the daemon fed during the step
+			daemonAlive(fetchImageTimeout)
+		} else {
+			daemonAlive(daemonTickTimeout)
+		}
+		if err = upgradeStepActions[idx](j); err != nil {
+			j.logger().Errorf("dcstorage upgrade fail at step %s,err: %v", j.Step, err)
+			failUpgrade(j, err)
+			return
+		}
+		if err = j.transition(upgradeStepOrder[idx+1]); err != nil {
+			failUpgrade(j, err)
+			return
+		}
+	}
+	return
+}
+
+// Finish a failed upgrade. Before the old dcstorage is removed it is simply restarted,afterwards it is recreated from the previous image.
+func failUpgrade(j *UpgradeJournal, cause error) {
+	j.FailedStep = j.Step
+	j.Error = cause.Error()
+	if !j.pastOldRemoval() {
+		abortUpgrade(j)
+		return
+	}
+	rollbackUpgrade(j)
+}
+
+// Replace the new dcstorage with a container of the previous image and restore the config
+func rollbackUpgrade(j *UpgradeJournal) {
+	j.logger().Warnf("dcstorage upgrade to version %s failed at step %s, roll back to %s,err: %s", j.Program.Version, j.FailedStep, j.PreviousImage, j.Error)
+	stopUpgradeInDocker()
+	err := removeDcStorageNodeInDocker()
+	if err != nil {
+		j.logger().Errorf("rollback: remove new dcstorage container fail,err: %v", err)
+		j.Error = fmt.Sprintf("%s; rollback fail: %v", j.Error, err)
+		j.transition(upgradeStepFailed)
+		return
+	}
+	config.RunningConfig.NodeImage = j.PreviousImage
+	if err = config.SaveConfig(config.RunningConfig); err != nil {
+		j.logger().Errorf("rollback: restore config fail,err: %v", err)
+	}
+	if err = restoreDcstorage(); err != nil {
+		j.logger().Errorf("rollback: start dcstorage %s fail,err: %v", j.PreviousImage, err)
+		j.Error = fmt.Sprintf("%s; rollback fail: %v", j.Error, err)
+		j.transition(upgradeStepFailed)
+		return
+	}
+	j.logger().Warnf("dcstorage rolled back to %s", j.PreviousImage)
+	j.transition(upgradeStepRolledBack)
+}
+
+// Return an error if the running dcstorage container has been restarted by docker too often
+func checkDcstorageCrashLoop() (err error) {
+	status := getContainerStatus(nodeContainerName)
+	if status.RestartCount >= crashLoopRestartCount {
+		err = fmt.Errorf("dcstorage %s is crash looping,restart count: %d", status.Image, status.RestartCount)
+	}
+	return
+}
+
+// Restore the old dcstorage when the upgrade is stopped before its container is removed
+func abortUpgrade(j *UpgradeJournal) {
+	j.logger().Infof("abort dcstorage upgrade at step %s, restore dcstorage %s", j.Step, j.PreviousImage)
+	stopUpgradeInDocker()
+	config.RunningConfig.NodeImage = j.PreviousImage
+	if err := restoreDcstorage(); err != nil {
+		j.logger().Errorf("restore dcstorage fail,err: %v", err)
+	}
+	j.Aborts++
+	j.transition(upgradeStepAborted)
+}
+
+// Continue or abort an upgrade that was interrupted by a restart of the daemon
+func resumeUpgrade() {
+	j, err := readUpgradeJournal()
+	if err != nil {
+		log.Errorf("read upgrade journal fail,err: %v", err)
+		return
+	}
+	if j == nil {
+		return
+	}
+	if j.Step == upgradeStepCompleted {
+		lastUpgradeGauge.Set(float64(j.UpdatedAt.Unix()))
+	}
+	if j.terminal() {
+		return
+	}
+	j.logger().Infof("found interrupted dcstorage upgrade to version %s at step %s", j.Program.Version, j.Step)
+	if !j.pastOldRemoval() {
+		j.FailedStep = j.Step
+		j.Error = "interrupted by restart"
+		abortUpgrade(j)
+		return
+	}
+	j.logger().Infof("resume dcstorage upgrade from step %s", j.Step)
+	upgradeAttemptsCounter.Inc()
+	if err = runUpgradeSteps(j); err != nil {
+		upgradeFailuresCounter.Inc()
+		return
+	}
+	lastUpgradeGauge.SetToCurrentTime()
+}
+
+// Obtain the image of the new dcstorage. If it exists in the DC network, use the image in the DC network. Otherwise, use the image corresponding to the registry in the configuration file.
+func fetchUpgradeImage(j *UpgradeJournal) (err error) {
+	programInfo := &j.Program
+	tagUrl := programInfo.OriginUrl
+	imageLoadSuccess := false
+	for _, mCid := range programInfo.MirrCids {
+		//Get the backup node address where the mcid file is located
+		fileSize, addrInfos, err := blockchain.GetPeerAddrsForCid(mCid)
+		if err != nil || len(addrInfos) == 0 {
+			continue
+		}
+		tObj := &util.TransmitObj{
+			TotalSize: uint64(fileSize),
+			LogFlag:   true,
+		}
+		savePath := fmt.Sprintf("/tmp/%s.tar", mCid)
+		err = util.DownloadFromIpfs(mCid, "", savePath, addrInfos, time.Hour, tObj)
+		if err == nil {
+			//Talk about image import obtained from DC network
+			err = loadDcStorageImage(context.Background(), savePath)
+			if err == nil {
+				imageLoadSuccess = true
+				break
+			}
+		}
+	}
+	if !imageLoadSuccess {
+		// Obtain the corresponding image according to the corresponding registry address in the configuration file
+		if config.RunningConfig.Registry == "cn" {
+			tagUrl = programInfo.MirrorUrl
+		}
+		// Pull the new version of dcstorage program image
+		err = pullDcStorageNodeImage(tagUrl)
+		if err != nil {
+			if config.RunningConfig.Registry == "cn" {
+				tagUrl = programInfo.OriginUrl
+			} else {
+				tagUrl = programInfo.MirrorUrl
+			}
+			err = pullDcStorageNodeImage(tagUrl)
+			if err != nil {
+				j.logger().Errorf("pullDcStorageNodeImage fail,err: %v", err)
+				return
+			}
+		}
+	}
+	j.TargetImage = tagUrl
+	return
+}
+
+// First close the upgrade assistant program. Because whether the upgrade is successful or not, the internal flag of dcupgrade will only be reset when restarting, so it must be closed first.
+func startUpgradeHelper(j *UpgradeJournal) (err error) {
+	stopUpgradeInDocker()
+	if util.IsSgx2Support() { //Sgx2 environment, you need to introduce an upgrade assistant program to transfer the node key
+		// Run the upgrade assistant
+		err = startDcupgradeInDocker()
+		if err != nil {
+			j.logger().Errorf("startDcupgradeInDocker fail,err: %v", err)
+		}
+	}
+	return
+}
+
+// Wait for dcupdate to successfully obtain the node key
+func waitHelperGetPeerSecret(j *UpgradeJournal) (err error) {
+	if !util.IsSgx2Support() {
+		return
+	}
+	_, err = waitDcUpdateGetPeerSecret()
+	return
+}
+
+// Close the currently running dcstorage
+func stopOldDcstorage(j *UpgradeJournal) (err error) {
+	err = stopDcnodeInDocker()
+	if err != nil {
+		j.logger().Errorf("stopDcnodeInDocker fail,err: %v", err)
+		time.Sleep(10 * time.Second) //If you do not exit directly, the loop may fail due to apparmor, and you will never be able to upgrade.
+	}
+	return nil
+}
+
+// Delete the docker container of the old version of dcstoragenode
+func removeOldDcstorage(j *UpgradeJournal) (err error) {
+	err = removeDcStorageNodeInDocker()
+	if err != nil {
+		j.logger().Errorf("removeDcStorageNodeInDocker fail,err: %v", err)
+	}
+	return
+}
+
+// Run the downloaded dcstorage program
+func startNewDcstorage(j *UpgradeJournal) (err error) {
+	//Update the image of dc storagenode to ensure that when starting, the new version of dcstorage is started.
+	config.RunningConfig.NodeImage = j.TargetImage
+	err = startDcStorageNode()
+	if err != nil {
+		j.logger().Errorf("upgrade-startDcStorageNode fail,err: %v", err)
+	}
+	return
+}
+
+// Wait for the new version of dcstorage to successfully obtain the node key
+func waitNewDcstorageGetPeerSecret(j *UpgradeJournal) (err error) {
+	j.logger().Info("wait new version to get peer secret")
+	if !util.IsSgx2Support() {
+		return
+	}
+	_, err = waitNewDcGetPeerSecret()
+	if err != nil {
+		return
+	}
+	stopUpgradeInDocker()
+	j.logger().Infof("new version dcstorage  get peer sceret success")
+	return
+}
+
+// Save the configuration file to ensure that the next time you start it, the new version of dcstorage will be started.
+func saveUpgradeConfig(j *UpgradeJournal) (err error) {
+	config.RunningConfig.NodeImage = j.TargetImage
+	return config.SaveConfig(config.RunningConfig)
+}
+
+// Wait for dcstorage to restart successfully after obtaining the secret and check its version. Wait up to 10 minutes.
+func verifyNewDcstorage(j *UpgradeJournal) (err error) {
+	programInfo := &j.Program
+	j.logger().Info("wait new version dcstorage to start with secret, max wait 10 minutes...")
+	version, enclaveId := "", ""
+	count := 0
+	for {
+		//Determine whether the new version of the program is running normally by checking the version
+		version, enclaveId, err = getVersionByHttpGet(dcStorageListenPort)
+		if err == nil {
+			break
+		}
+		if err = checkDcstorageCrashLoop(); err != nil {
+			return
+		}
+		time.Sleep(10 * time.Second)
+		count++
+		if count > 60 {
+			j.logger().Errorf("new version dcstorage start fail,err : %v", err)
+			break
+		}
+	}
+	if version != programInfo.Version {
+		err = fmt.Errorf("dcstorage version check fail,version: %s, configedVersion: %s", version, programInfo.Version)
+		return
+	}
+	if enclaveId != programInfo.EnclaveId && util.IsSgx2Support() {
+		err = fmt.Errorf("dcstorage enclaveid check fail,enclaveId: %s, configedEnclaveId: %s", enclaveId, programInfo.EnclaveId)
+		return
+	}
+	j.logger().Infof("dcstorage upgrade success,version: %s,enclaveid: %s", version, enclaveId)
+	return
+}
@@ -0,0 +1,208 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dcnetio/dc/config"
+	"github.com/dcnetio/dc/util"
+	"github.com/docker/docker/api/types/container"
+)
+
+const (
+	testPreviousImage = "dcnetio/dcstorage:1.0.0"
+	testTargetImage   = "dcnetio/dcstorage:1.1.0"
+)
+
+// Create a container in the fake runtime,running if running is set
+func addTestContainer(t *testing.T, fake *util.FakeRuntime, name string, image string, running bool) *util.FakeContainer {
+	t.Helper()
+	ctx := context.Background()
+	id, err := fake.ContainerCreate(ctx, name, &container.Config{Image: image}, &container.HostConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if running {
+		if err = fake.ContainerStart(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fake.Container(name)
+}
+
+// Run the test against a fake runtime,with the journal and config file in a temp dir
+func useTestUpgradeState(t *testing.T) *util.FakeRuntime {
+	t.Helper()
+	fake := util.NewFakeRuntime()
+	util.SetContainerRuntime(fake)
+	savedJournalFilepath, savedConfigFilepath, savedNodeImage := upgradeJournalFilepath, config.Config_file_path, config.RunningConfig.NodeImage
+	savedActions, savedRestore := upgradeStepActions, restoreDcstorage
+	t.Cleanup(func() {
+		util.SetContainerRuntime(nil)
+		upgradeJournalFilepath, config.Config_file_path, config.RunningConfig.NodeImage = savedJournalFilepath, savedConfigFilepath, savedNodeImage
+		upgradeStepActions, restoreDcstorage = savedActions, savedRestore
+	})
+	dir := t.TempDir()
+	upgradeJournalFilepath = filepath.Join(dir, "upgrade.journal")
+	config.Config_file_path = filepath.Join(dir, "manage_config.yaml")
+	config.RunningConfig.NodeImage = testPreviousImage
+	return fake
+}
+
+// Step actions that record the steps they run and fail at step index failAt,-1 for none
+func testStepActions(failAt int, ran *[]string) []func(j *UpgradeJournal) error {
+	actions := make([]func(j *UpgradeJournal) error, len(upgradeStepOrder)-1)
+	for i := range actions {
+		idx := i
+		actions[idx] = func(j *UpgradeJournal) error {
+			*ran = append(*ran, upgradeStepOrder[idx])
+			if idx == failAt {
+				return errors.New("step failed")
+			}
+			switch upgradeStepOrder[idx+1] {
+			case upgradeStepImageReady:
+				j.TargetImage = testTargetImage
+			case upgradeStepNewStarted, upgradeStepConfigSaved:
+				config.RunningConfig.NodeImage = j.TargetImage
+			}
+			return nil
+		}
+	}
+	return actions
+}
+
+func TestRunUpgradeSteps(t *testing.T) {
+	tests := []struct {
+		name           string
+		fromStep       string //step of a resumed journal,empty for a new upgrade
+		failAt         int
+		restoreErr     error
+		removeErr      error //error removing the new dcstorage container on roll back
+		wantStep       string
+		wantFailedStep string
+		wantRan        int //step actions run
+		wantRestored   bool
+		wantNodeImage  string
+	}{
+		{name: "completed", failAt: -1, wantStep: upgradeStepCompleted, wantRan: 9, wantNodeImage: testTargetImage},
+		{name: "image fetch fails", failAt: 0, wantStep: upgradeStepAborted, wantFailedStep: upgradeStepStarted, wantRan: 1, wantRestored: true, wantNodeImage: testPreviousImage},
+		{name: "stop old fails", failAt: 3, wantStep: upgradeStepAborted, wantFailedStep: upgradeStepSecretHandedOver, wantRan: 4, wantRestored: true, wantNodeImage: testPreviousImage},
+		{name: "start new fails", failAt: 5, wantStep: upgradeStepRolledBack, wantFailedStep: upgradeStepOldRemoved, wantRan: 6, wantRestored: true, wantNodeImage: testPreviousImage},
+		{name: "verify fails", failAt: 8, wantStep: upgradeStepRolledBack, wantFailedStep: upgradeStepConfigSaved, wantRan: 9, wantRestored: true, wantNodeImage: testPreviousImage},
+		{name: "restore fails", failAt: 5, restoreErr: errors.New("pccs start fail"), wantStep: upgradeStepFailed, wantFailedStep: upgradeStepOldRemoved, wantRan: 6, wantRestored: true, wantNodeImage: testPreviousImage},
+		{name: "remove new fails", failAt: 8, removeErr: errors.New("device busy"), wantStep: upgradeStepFailed, wantFailedStep: upgradeStepConfigSaved, wantRan: 9, wantNodeImage: testTargetImage},
+		{name: "resumed after old removed", fromStep: upgradeStepOldRemoved, failAt: -1, wantStep: upgradeStepCompleted, wantRan: 4, wantNodeImage: testTargetImage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := useTestUpgradeState(t)
+			addTestContainer(t, fake, nodeContainerName, testPreviousImage, true)
+			fake.Errs["ContainerRemove"] = tt.removeErr
+			ran := []string{}
+			upgradeStepActions = testStepActions(tt.failAt, &ran)
+			restored := false
+			restoreDcstorage = func() error {
+				restored = true
+				return tt.restoreErr
+			}
+			j := newUpgradeJournal(&config.DcProgram{Version: "1.1.0", EnclaveId: "e1"})
+			if tt.fromStep != "" {
+				j.Step = tt.fromStep
+				j.TargetImage = testTargetImage
+			}
+			err := runUpgradeSteps(j)
+			if (err != nil) != (tt.failAt >= 0) {
+				t.Fatalf("err = %v,failAt %d", err, tt.failAt)
+			}
+			if j.Step != tt.wantStep || j.FailedStep != tt.wantFailedStep {
+				t.Errorf("step %s,failed step %s,want %s,%s", j.Step, j.FailedStep, tt.wantStep, tt.wantFailedStep)
+			}
+			if len(ran) != tt.wantRan {
+				t.Errorf("ran %v,want %d steps", ran, tt.wantRan)
+			}
+			if restored != tt.wantRestored {
+				t.Errorf("restored = %v,want %v", restored, tt.wantRestored)
+			}
+			if config.RunningConfig.NodeImage != tt.wantNodeImage {
+				t.Errorf("nodeImage = %s,want %s", config.RunningConfig.NodeImage, tt.wantNodeImage)
+			}
+			saved, err := readUpgradeJournal()
+			if err != nil || saved == nil {
+				t.Fatalf("read journal fail,err: %v", err)
+			}
+			if saved.Step != j.Step || saved.History[len(saved.History)-1].Step != j.Step {
+				t.Errorf("journal step %s,want %s", saved.Step, j.Step)
+			}
+			if tt.wantStep == upgradeStepRolledBack {
+				savedConfig, _ := config.ReadConfig()
+				if savedConfig == nil || savedConfig.NodeImage != testPreviousImage {
+					t.Errorf("config is not restored to %s", testPreviousImage)
+				}
+			}
+		})
+	}
+}
+
+func TestUpgradeJournalFailedFor(t *testing.T) {
+	program := &config.DcProgram{Version: "1.1.0", EnclaveId: "e1"}
+	tests := []struct {
+		name    string
+		step    string
+		program config.DcProgram
+		want    bool
+	}{
+		{name: "rolled back", step: upgradeStepRolledBack, program: *program, want: true},
+		{name: "failed", step: upgradeStepFailed, program: *program, want: true},
+		{name: "aborted", step: upgradeStepAborted, program: *program},
+		{name: "completed", step: upgradeStepCompleted, program: *program},
+		{name: "in progress", step: upgradeStepOldStopped, program: *program},
+		{name: "other version", step: upgradeStepRolledBack, program: config.DcProgram{Version: "1.0.9", EnclaveId: "e1"}},
+		{name: "other enclaveid", step: upgradeStepRolledBack, program: config.DcProgram{Version: "1.1.0", EnclaveId: "e2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := &UpgradeJournal{Step: tt.step, Program: tt.program}
+			if got := j.failedFor(program); got != tt.want {
+				t.Errorf("failedFor = %v,want %v", got, tt.want)
+			}
+		})
+	}
+	var j *UpgradeJournal
+	if j.failedFor(program) {
+		t.Error("failedFor without journal = true")
+	}
+}
+
+func TestUpgradeJournalAbortedBackoff(t *testing.T) {
+	program := &config.DcProgram{Version: "1.1.0", EnclaveId: "e1"}
+	updatedAt := time.Now()
+	tests := []struct {
+		name    string
+		step    string
+		program config.DcProgram
+		aborts  int
+		want    time.Duration //zero for no back off
+	}{
+		{name: "first abort", step: upgradeStepAborted, program: *program, aborts: 1, want: abortedRetryBackoff},
+		{name: "journal without aborts", step: upgradeStepAborted, program: *program, want: abortedRetryBackoff},
+		{name: "third abort", step: upgradeStepAborted, program: *program, aborts: 3, want: 4 * abortedRetryBackoff},
+		{name: "capped", step: upgradeStepAborted, program: *program, aborts: 30, want: abortedRetryBackoffMax},
+		{name: "rolled back", step: upgradeStepRolledBack, program: *program, aborts: 1},
+		{name: "other version", step: upgradeStepAborted, program: config.DcProgram{Version: "1.0.9", EnclaveId: "e1"}, aborts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := &UpgradeJournal{Step: tt.step, Program: tt.program, Aborts: tt.aborts, UpdatedAt: updatedAt}
+			var want time.Time
+			if tt.want > 0 {
+				want = updatedAt.Add(tt.want)
+			}
+			if got := j.abortedBackoffUntil(program); !got.Equal(want) {
+				t.Errorf("abortedBackoffUntil = %s,want %s", got, want)
+			}
+		})
+	}
+}
@@ -4,8 +4,8 @@ import (
 	_ "embed"
 	"encoding/json"
 	"fmt"
-	"log"
 	"os"
+	"path/filepath"
 
 	yaml "gopkg.in/yaml.v2"
 )
@@ -24,86 +24,175 @@ var GetVersion = func() (verStr string) {
 	return
 }()
 
-const Config_file_path = "/opt/dcnetio/etc/manage_config.yaml"
-const CommitBasePubkey = "bl3kr5jjklu2iijnmyhz7cy5lz3h5xhrlp7sim54bjhc4v3ztzfdq" //The pubkey used by the technical committee to release the upgraded version of dcstorage
+var Config_file_path = DefaultHomeDir + "/etc/manage_config.yaml"
+
+const CommitBasePubkey = "bl3kr5jjklu2iijnmyhz7cy5lz3h5xhrlp7sim54bjhc4v3ztzfdq" //The default pubkey used by the technical committee to release the upgraded version of dcstorage,see commitBasePubkey
 // Node related program version information
 type DcProgram struct {
-	OriginUrl string   `yaml:"originUrl"` //Program download address
-	MirrorUrl string   `yaml:"mirrorUrl"` //Program file download path mirror address, used as an alternative download address when nodes download program files.
-	EnclaveId string   `yaml:"enclaveId"` //The tee enclaveid corresponding to the program
-	Version   string   `yaml:"version"`   //Program version information
-	MirrCids  []string `yaml:"mirrCids"`  //The cid list of the program file, the cid list of the docker image in the DC network
+	OriginUrl string   `yaml:"originUrl" json:"originUrl"` //Program download address
+	MirrorUrl string   `yaml:"mirrorUrl" json:"mirrorUrl"` //Program file download path mirror address, used as an alternative download address when nodes download program files.
+	EnclaveId string   `yaml:"enclaveId" json:"enclaveId"` //The tee enclaveid corresponding to the program
+	Version   string   `yaml:"version" json:"version"`     //Program version information
+	MirrCids  []string `yaml:"mirrCids" json:"mirrCids"`   //The cid list of the program file, the cid list of the docker image in the DC network
+}
+
+// Policy followed by the upgrade daemon when a new dcstorage version is available
+type UpgradePolicy struct {
+	Hold            bool                `yaml:"hold" json:"hold"`                       //Pin the current dcstorage version, the daemon does not upgrade
+	Timezone        string              `yaml:"timezone" json:"timezone"`               //IANA timezone of the maintenance windows, empty means local time
+	Windows         []MaintenanceWindow `yaml:"windows" json:"windows"`                 //Upgrade only inside these windows, empty means any time
+	RolloutDelayMax int                 `yaml:"rolloutDelayMax" json:"rolloutDelayMax"` //Max seconds to wait after a new version is first seen, the delay of each node is derived from its peer id
+}
+
+// Format,level and rotation of the log file of dc
+type LogConfig struct {
+	Format     string `yaml:"format" json:"format"`         //text or json
+	Level      string `yaml:"level" json:"level"`           //debug,info,warn or error
+	MaxSize    int    `yaml:"maxSize" json:"maxSize"`       //Megabytes after which the log file is rotated, 0 disables rotation by size
+	MaxAge     string `yaml:"maxAge" json:"maxAge"`         //Age after which the log file is rotated, e.g. 24h, empty disables rotation by age
+	MaxBackups int    `yaml:"maxBackups" json:"maxBackups"` //Rotated files to keep, 0 keeps all
+	Compress   bool   `yaml:"compress" json:"compress"`     //gzip the rotated files
 }
 
-var RunningConfig = &DcManageConfig{
-	ChainNodeName:        "",
-	ValidatorFlag:        "",
-	ChainSyncMode:        "full", //Blockchain synchronization mode supports full, fast, fast-unsafe, warp and defaults to fast
-	ChainWsUrl:           "ws://127.0.0.1:9944",
-	ChainRpcListenPort:   9944, //New version of chain node rpc listening port, default 9944
-	PccsKey:              "",   //Subscription key for intel pccs service
-	ChainImage:           "ghcr.io/dcnetio/dcchain:latest",
-	NodeImage:            "ghcr.io/dcnetio/dcstorage:latest",
-	UpgradeImage:         "ghcr.io/dcnetio/dcupgrade:latest",
-	TeeReportServerImage: "ghcr.io/dcnetio/dcteereportserver:0.1.2",
-	PccsImage:            "ghcr.io/dcnetio/pccs:latest",
-	Registry:             "ghcr.io/dcnetio",
-	ChainBootNode:        "",
-	ChainExposeFlag:      "", //Whether to enable the RPC port of the chain node to be exposed to the public network. It is not enabled by default.
-	NewVersion: DcProgram{
-		OriginUrl: "",
-		MirrorUrl: "",
-		EnclaveId: "",
-		Version:   "",
-		MirrCids:  []string{},
-	},
+// Time range in which the daemon may upgrade dcstorage
+type MaintenanceWindow struct {
+	Days      []string `yaml:"days" json:"days"`           //mon,tue,wed,thu,fri,sat,sun, empty means every day
+	StartHour int      `yaml:"startHour" json:"startHour"` //0-23
+	EndHour   int      `yaml:"endHour" json:"endHour"`     //1-24, exclusive. A window with endHour <= startHour ends on the next day
+}
+
+const defaultMetricsListenPort = 9810
+const defaultMetricsListenAddr = "127.0.0.1"
+const defaultRolloutDelayMax = 86400 //Spread the upgrade of all nodes over 24 hours
+const defaultStorageQuiesceTimeout = 120
+
+var RunningConfig = DefaultConfig()
+
+// DefaultConfig returns the config used when there is no config file
+func DefaultConfig() *DcManageConfig {
+	return &DcManageConfig{
+		ConfigVersion:         CurrentConfigVersion,
+		ChainNodeName:         "",
+		ValidatorFlag:         "",
+		ChainSyncMode:         "full", //Blockchain synchronization mode supports full, fast, fast-unsafe, warp and defaults to fast
+		ChainWsUrl:            "ws://127.0.0.1:9944",
+		ChainRpcListenPort:    9944, //New version of chain node rpc listening port, default 9944
+		PccsKey:               "",   //Subscription key for intel pccs service
+		ChainImage:            "ghcr.io/dcnetio/dcchain:latest",
+		NodeImage:             "ghcr.io/dcnetio/dcstorage:latest",
+		UpgradeImage:          "ghcr.io/dcnetio/dcupgrade:latest",
+		TeeReportServerImage:  "ghcr.io/dcnetio/dcteereportserver:0.1.2",
+		PccsImage:             "ghcr.io/dcnetio/pccs:latest",
+		Registry:              "ghcr.io/dcnetio",
+		ChainBootNode:         "",
+		ChainSpec:             "mainnet",
+		CommitBasePubkey:      CommitBasePubkey,
+		ChainP2pPort:          60666,
+		StorageListenPort:     6667,                         //Local http port of dcstorage
+		UpgradeListenPort:     6666,                         //Local http port of dcupgrade
+		ChainDataDir:          "",                           //<home>/chaindata
+		StorageQuiesceTimeout: defaultStorageQuiesceTimeout, //Seconds to wait for dcstorage to flush before its container is stopped, 0 stops it without flush
+		Logging: LogConfig{ //Rotate the log file daily or at 100MB, keep a week of compressed files
+			Format:     "text",
+			Level:      "info",
+			MaxSize:    100,
+			MaxAge:     "24h",
+			MaxBackups: 7,
+			Compress:   true,
+		},
+		ChainExposeFlag:   "",                       //Whether to enable the RPC port of the chain node to be exposed to the public network. It is not enabled by default.
+		MetricsListenPort: defaultMetricsListenPort, //Listening port of the prometheus metrics endpoint served by the upgrade daemon, 0 disables it
+		MetricsListenAddr: defaultMetricsListenAddr, //Listening address of the metrics endpoint, only local scrapers by default
+		UpgradePolicy: UpgradePolicy{
+			RolloutDelayMax: defaultRolloutDelayMax,
+		},
+		NewVersion: DcProgram{
+			OriginUrl: "",
+			MirrorUrl: "",
+			EnclaveId: "",
+			Version:   "",
+			MirrCids:  []string{},
+		},
+	}
 }
 
 type DcManageConfig struct {
-	ChainNodeName        string    `yaml:"chainNodeName"`
-	ValidatorFlag        string    `yaml:"validatorFlag"`
-	ChainSyncMode        string    `yaml:"chainSyncMode"`
-	ChainWsUrl           string    `yaml:"chainWsUrl"`
-	ChainRpcListenPort   int       `yaml:"chainRpcListenPort"`
-	PccsKey              string    `yaml:"pccsKey"`
-	ChainImage           string    `yaml:"chainImage"`
-	NodeImage            string    `yaml:"nodeImage"`
-	UpgradeImage         string    `yaml:"upgradeImage"`
-	TeeReportServerImage string    `yaml:"teeReportServerImage"`
-	PccsImage            string    `yaml:"pccsImage"`
-	Registry             string    `yaml:"registry"`
-	ChainBootNode        string    `yaml:"chainBootNode"`
-	ChainExposeFlag      string    `yaml:"chainExposeFlag"`
-	NewVersion           DcProgram `yaml:"newVersion"`
+	ConfigVersion         int                        `yaml:"configVersion" json:"configVersion"`
+	ChainNodeName         string                     `yaml:"chainNodeName" json:"chainNodeName"`
+	ValidatorFlag         string                     `yaml:"validatorFlag" json:"validatorFlag"`
+	ChainSyncMode         string                     `yaml:"chainSyncMode" json:"chainSyncMode"`
+	ChainWsUrl            string                     `yaml:"chainWsUrl" json:"chainWsUrl"`
+	ChainRpcListenPort    int                        `yaml:"chainRpcListenPort" json:"chainRpcListenPort"`
+	PccsKey               string                     `yaml:"pccsKey" json:"pccsKey"`
+	ChainImage            string                     `yaml:"chainImage" json:"chainImage"`
+	NodeImage             string                     `yaml:"nodeImage" json:"nodeImage"`
+	UpgradeImage          string                     `yaml:"upgradeImage" json:"upgradeImage"`
+	TeeReportServerImage  string                     `yaml:"teeReportServerImage" json:"teeReportServerImage"`
+	PccsImage             string                     `yaml:"pccsImage" json:"pccsImage"`
+	Registry              string                     `yaml:"registry" json:"registry"`
+	ChainBootNode         string                     `yaml:"chainBootNode" json:"chainBootNode"`
+	ChainExposeFlag       string                     `yaml:"chainExposeFlag" json:"chainExposeFlag"`
+	MetricsListenPort     int                        `yaml:"metricsListenPort" json:"metricsListenPort"`
+	MetricsListenAddr     string                     `yaml:"metricsListenAddr" json:"metricsListenAddr"`
+	UpgradePolicy         UpgradePolicy              `yaml:"upgradePolicy" json:"upgradePolicy"`
+	NewVersion            DcProgram                  `yaml:"newVersion" json:"newVersion"`
+	ChainSpec             string                     `yaml:"chainSpec" json:"chainSpec"`
+	CommitBasePubkey      string                     `yaml:"commitBasePubkey" json:"commitBasePubkey"`
+	ChainP2pPort          int                        `yaml:"chainP2pPort" json:"chainP2pPort"`
+	StorageListenPort     int                        `yaml:"storageListenPort" json:"storageListenPort"`
+	UpgradeListenPort     int                        `yaml:"upgradeListenPort" json:"upgradeListenPort"`
+	ChainDataDir          string                     `yaml:"chainDataDir" json:"chainDataDir"`
+	StorageQuiesceTimeout int                        `yaml:"storageQuiesceTimeout" json:"storageQuiesceTimeout"`
+	Logging               LogConfig                  `yaml:"logging" json:"logging"`
+	Paths                 PathConfig                 `yaml:"paths" json:"paths"`
+	Services              map[string]*ServiceSpec    `yaml:"services,omitempty" json:"services,omitempty"` //Overrides of the container specs, by service name
+	Profiles              map[string]*NetworkProfile `yaml:"profiles,omitempty" json:"profiles,omitempty"` //Named network profiles selected by --profile
 }
 
+// ReadConfig reads the config file, migrates it to CurrentConfigVersion if it is older,applies the active profile and validates it.
+// An invalid config is still returned together with the ValidationError, so that it can be fixed by "dc config".
 func ReadConfig() (*DcManageConfig, error) {
 	yamlFile, err := os.ReadFile(Config_file_path)
 	if err != nil {
-		log.Fatalf("yamlFile.Get err #%v ", err)
-		return nil, err
+		return nil, fmt.Errorf("read config file %s fail,err: %v", Config_file_path, err)
 	}
 	localconfig := &DcManageConfig{}
 	err = yaml.Unmarshal(yamlFile, localconfig)
 	if err != nil {
-		log.Fatalf("Unmarshal: %v", err)
+		return nil, fmt.Errorf("parse config file %s fail,err: %v", Config_file_path, err)
+	}
+	if _, err = migrateConfig(localconfig, yamlFile); err != nil {
 		return nil, err
 	}
-
-	return localconfig, nil
+	fileConfig = localconfig
+	if ActiveProfile != "" {
+		if localconfig, err = localconfig.withProfile(ActiveProfile); err != nil {
+			return nil, err
+		}
+	}
+	return localconfig, localconfig.Validate()
 }
 
+// SaveConfig writes config to the config file, with a profile active the values of the profile are saved in it
 func SaveConfig(config *DcManageConfig) (err error) {
+	if ActiveProfile != "" && fileConfig != nil {
+		config = splitProfile(config)
+	}
 	fileBytes, err := yaml.Marshal(config)
 	if err != nil {
-		log.Fatalf("Marshal: %v", err)
-		return err
+		return fmt.Errorf("marshal config fail,err: %v", err)
 	}
-	err = os.WriteFile(Config_file_path, fileBytes, os.ModePerm)
-	if err != nil {
-		log.Fatalf("yamlFile.Save err #%v ", err)
-		return err
+	if err = os.MkdirAll(filepath.Dir(Config_file_path), 0755); err != nil {
+		return fmt.Errorf("create config directory fail,err: %v", err)
 	}
-
+	//Write to a temporary file first so that an interrupted save never leaves a truncated config
+	tmpPath := Config_file_path + ".tmp"
+	if err = os.WriteFile(tmpPath, fileBytes, 0644); err != nil {
+		return fmt.Errorf("save config file fail,err: %v", err)
+	}
+	if err = os.Rename(tmpPath, Config_file_path); err != nil {
+		return fmt.Errorf("save config file fail,err: %v", err)
+	}
+	fileConfig = config
 	return nil
 }
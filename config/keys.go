@@ -0,0 +1,132 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Clone returns a deep copy of the config
+func (c *DcManageConfig) Clone() *DcManageConfig {
+	content, _ := yaml.Marshal(c)
+	clone := &DcManageConfig{}
+	yaml.Unmarshal(content, clone)
+	return clone
+}
+
+// Keys of the config are the yaml names of the DcManageConfig fields, nested fields and map entries are joined by "." (e.g. upgradePolicy.hold,services.chain.extraArgs)
+
+// ConfigKeys lists the keys of all fields that hold a value, in file order
+func ConfigKeys() (keys []string) {
+	var walk func(t reflect.Type, prefix string)
+	walk = func(t reflect.Type, prefix string) {
+		for i := 0; i < t.NumField(); i++ {
+			key := prefix + yamlName(t.Field(i))
+			if t.Field(i).Type.Kind() == reflect.Struct {
+				walk(t.Field(i).Type, key+".")
+				continue
+			}
+			keys = append(keys, key)
+		}
+	}
+	walk(reflect.TypeOf(DcManageConfig{}), "")
+	return
+}
+
+func yamlName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name
+}
+
+// Find the field of key in c, key may also name a nested struct (e.g. upgradePolicy)
+// Entries of the maps (services.chain,profiles.testnet) are created when they are missing, so that they can be set.
+func lookupField(c *DcManageConfig, key string) (value reflect.Value, err error) {
+	value = reflect.ValueOf(c).Elem()
+	for _, name := range strings.Split(key, ".") {
+		if value.Kind() == reflect.Map && value.Type().Elem().Kind() == reflect.Ptr {
+			if value.IsNil() {
+				value.Set(reflect.MakeMap(value.Type()))
+			}
+			entry := value.MapIndex(reflect.ValueOf(name))
+			if !entry.IsValid() || entry.IsNil() {
+				entry = reflect.New(value.Type().Elem().Elem())
+				value.SetMapIndex(reflect.ValueOf(name), entry)
+			}
+			value = entry.Elem()
+			continue
+		}
+		if value.Kind() != reflect.Struct {
+			return value, fmt.Errorf("unknown config key: %s", key)
+		}
+		found := false
+		for i := 0; i < value.NumField(); i++ {
+			if yamlName(value.Type().Field(i)) == name {
+				value = value.Field(i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return value, fmt.Errorf("unknown config key: %s", key)
+		}
+	}
+	return
+}
+
+// GetField returns the field of key as it is
+func (c *DcManageConfig) GetField(key string) (interface{}, error) {
+	value, err := lookupField(c, key)
+	if err != nil {
+		return nil, err
+	}
+	return value.Interface(), nil
+}
+
+// GetValue returns the field of key, strings and numbers as they are and other values in json,
+// which is also accepted by SetValue
+func (c *DcManageConfig) GetValue(key string) (string, error) {
+	value, err := lookupField(c, key)
+	if err != nil {
+		return "", err
+	}
+	switch value.Kind() {
+	case reflect.String:
+		return value.String(), nil
+	case reflect.Int, reflect.Bool:
+		return fmt.Sprint(value.Interface()), nil
+	}
+	content, err := json.Marshal(value.Interface())
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// SetValue parses value into the field of key. Strings are taken as they are,
+// other types are parsed as yaml, e.g. "true", "9944", "[sat, sun]" or "{hold: true}".
+// The result is not validated, call Validate before saving it.
+func (c *DcManageConfig) SetValue(key string, value string) error {
+	if key == "configVersion" {
+		return fmt.Errorf("configVersion is managed by dc and can not be set")
+	}
+	field, err := lookupField(c, key)
+	if err != nil {
+		return err
+	}
+	if field.Kind() == reflect.String {
+		field.SetString(value)
+		return nil
+	}
+	parsed := reflect.New(field.Type())
+	if err = yaml.UnmarshalStrict([]byte(value), parsed.Interface()); err != nil {
+		return fmt.Errorf("invalid value for %s(%s): %v", key, field.Type(), err)
+	}
+	field.Set(parsed.Elem())
+	return nil
+}
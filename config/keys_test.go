@@ -0,0 +1,34 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClone(t *testing.T) {
+	c := DefaultConfig()
+	c.Services = map[string]*ServiceSpec{ServiceChain: {ExtraArgs: []string{"--rpc-methods", "unsafe"}}}
+	c.Profiles = map[string]*NetworkProfile{"testnet": {ChainSpec: "testnet", NewVersion: DcProgram{MirrCids: []string{"cid1"}}}}
+	c.UpgradePolicy.Windows = []MaintenanceWindow{{Days: []string{"sat"}, StartHour: 2, EndHour: 6}}
+	c.NewVersion.MirrCids = []string{"cid0"}
+	clone := c.Clone()
+	if !reflect.DeepEqual(clone.Services, c.Services) || !reflect.DeepEqual(clone.Profiles, c.Profiles) || !reflect.DeepEqual(clone.UpgradePolicy, c.UpgradePolicy) {
+		t.Fatalf("clone differs from the config: %+v", clone)
+	}
+	//Changes to the clone must not reach the original
+	clone.Services[ServiceChain].ExtraArgs[0] = "--validator"
+	clone.Services[ServiceStorage] = &ServiceSpec{}
+	clone.Profiles["testnet"].ChainSpec = "dev"
+	clone.Profiles["testnet"].NewVersion.MirrCids[0] = "cid2"
+	clone.UpgradePolicy.Windows[0].Days[0] = "sun"
+	clone.NewVersion.MirrCids[0] = "cid3"
+	if c.Services[ServiceChain].ExtraArgs[0] != "--rpc-methods" || c.Services[ServiceStorage] != nil {
+		t.Errorf("services are shared with the clone: %+v", c.Services)
+	}
+	if c.Profiles["testnet"].ChainSpec != "testnet" || c.Profiles["testnet"].NewVersion.MirrCids[0] != "cid1" {
+		t.Errorf("profiles are shared with the clone: %+v", c.Profiles["testnet"])
+	}
+	if c.UpgradePolicy.Windows[0].Days[0] != "sat" || c.NewVersion.MirrCids[0] != "cid0" {
+		t.Errorf("slices are shared with the clone: %+v,%v", c.UpgradePolicy.Windows, c.NewVersion.MirrCids)
+	}
+}
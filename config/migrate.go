@@ -0,0 +1,107 @@
+package config
+
+import (
+	"fmt"
+	"os"
+)
+
+// CurrentConfigVersion is the schema version of the config file written by this dc,
+// files without configVersion are version 1
+const CurrentConfigVersion = 6
+
+// A migration upgrades the config from version from to version from+1
+type configMigration struct {
+	from    int
+	migrate func(c *DcManageConfig)
+}
+
+// Migrations in version order, append one and increase CurrentConfigVersion when the schema changes
+var configMigrations = []configMigration{
+	{from: 1, migrate: migrateConfigV1ToV2},
+	{from: 2, migrate: migrateConfigV2ToV3},
+	{from: 3, migrate: migrateConfigV3ToV4},
+	{from: 4, migrate: migrateConfigV4ToV5},
+	{from: 5, migrate: migrateConfigV5ToV6},
+}
+
+// Version 2 adds metricsListenPort and upgradePolicy,and fills the images missing from the version 1 template
+func migrateConfigV1ToV2(c *DcManageConfig) {
+	defaults := DefaultConfig()
+	c.MetricsListenPort = defaults.MetricsListenPort
+	c.UpgradePolicy = defaults.UpgradePolicy
+	for _, image := range []struct{ value, def *string }{
+		{&c.ChainImage, &defaults.ChainImage},
+		{&c.NodeImage, &defaults.NodeImage},
+		{&c.UpgradeImage, &defaults.UpgradeImage},
+		{&c.TeeReportServerImage, &defaults.TeeReportServerImage},
+		{&c.PccsImage, &defaults.PccsImage},
+	} {
+		if *image.value == "" {
+			*image.value = *image.def
+		}
+	}
+}
+
+// Upgrade an older config read from the config file to CurrentConfigVersion,
+// the original file content is backed up before the migrated config is saved.
+// migrated is false if the config is already up to date.
+func migrateConfig(c *DcManageConfig, original []byte) (migrated bool, err error) {
+	if c.ConfigVersion == 0 {
+		c.ConfigVersion = 1
+	}
+	if c.ConfigVersion > CurrentConfigVersion {
+		err = fmt.Errorf("config file version %d is newer than the supported version %d,please upgrade dc", c.ConfigVersion, CurrentConfigVersion)
+		return
+	}
+	if c.ConfigVersion == CurrentConfigVersion {
+		return
+	}
+	backupPath := fmt.Sprintf("%s.v%d.bak", Config_file_path, c.ConfigVersion)
+	if err = os.WriteFile(backupPath, original, 0600); err != nil {
+		err = fmt.Errorf("backup config file to %s fail,err: %v", backupPath, err)
+		return
+	}
+	fromVersion := c.ConfigVersion
+	for _, m := range configMigrations {
+		if m.from == c.ConfigVersion {
+			m.migrate(c)
+			c.ConfigVersion++
+		}
+	}
+	if c.ConfigVersion != CurrentConfigVersion {
+		err = fmt.Errorf("no migration of config file from version %d", c.ConfigVersion)
+		return
+	}
+	if err = SaveConfig(c); err != nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "config file migrated from version %d to %d,the original is backed up to %s\n", fromVersion, CurrentConfigVersion, backupPath)
+	migrated = true
+	return
+}
+
+// Version 3 adds the network settings that can differ between profiles
+func migrateConfigV2ToV3(c *DcManageConfig) {
+	defaults := DefaultConfig()
+	c.ChainSpec = defaults.ChainSpec
+	c.CommitBasePubkey = defaults.CommitBasePubkey
+	c.ChainP2pPort = defaults.ChainP2pPort
+	c.StorageListenPort = defaults.StorageListenPort
+	c.UpgradeListenPort = defaults.UpgradeListenPort
+	c.ChainDataDir = defaults.ChainDataDir
+}
+
+// Version 4 adds storageQuiesceTimeout
+func migrateConfigV3ToV4(c *DcManageConfig) {
+	c.StorageQuiesceTimeout = DefaultConfig().StorageQuiesceTimeout
+}
+
+// Version 5 adds the format and rotation of the log file
+func migrateConfigV4ToV5(c *DcManageConfig) {
+	c.Logging = DefaultConfig().Logging
+}
+
+// Version 6 adds metricsListenAddr,the metrics endpoint of an older config listened on all interfaces
+func migrateConfigV5ToV6(c *DcManageConfig) {
+	c.MetricsListenAddr = DefaultConfig().MetricsListenAddr
+}
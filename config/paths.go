@@ -0,0 +1,97 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+const DefaultHomeDir = "/opt/dcnetio"
+
+// HomeDir is the install root, set by the DC_HOME environment variable or the global --home option
+var HomeDir = DefaultHomeDir
+
+// Directories used by dcmanager, empty ones are placed under the home directory
+type PathConfig struct {
+	LogFile       string `yaml:"logFile" json:"logFile"`             //log file of dcmanager, default <home>/log
+	DataDir       string `yaml:"dataDir" json:"dataDir"`             //state files of dcmanager (daemon pid,upgrade journal...), default <home>/data
+	DisksDir      string `yaml:"disksDir" json:"disksDir"`           //storage disks mounted into dcstorage, default <home>/disks
+	StorageEtcDir string `yaml:"storageEtcDir" json:"storageEtcDir"` //directory with dcstorage_config.yaml mounted into dcstorage, default <home>/etc
+	ProfilesDir   string `yaml:"profilesDir" json:"profilesDir"`     //data of the named profiles, default <home>/profiles
+}
+
+// SetHomeDir moves the install root, the config file is then read from <home>/etc/manage_config.yaml
+func SetHomeDir(dir string) error {
+	home, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("invalid home directory %s,err: %v", dir, err)
+	}
+	HomeDir = home
+	Config_file_path = filepath.Join(home, "etc", "manage_config.yaml")
+	return nil
+}
+
+func pathOrHome(path string, name string) string {
+	if path != "" {
+		return path
+	}
+	return filepath.Join(HomeDir, name)
+}
+
+func (c *DcManageConfig) GetLogFile() string {
+	return pathOrHome(c.Paths.LogFile, "log")
+}
+
+func (c *DcManageConfig) GetDataDir() string {
+	return pathOrHome(c.Paths.DataDir, "data")
+}
+
+func (c *DcManageConfig) GetDisksDir() string {
+	return pathOrHome(c.Paths.DisksDir, "disks")
+}
+
+func (c *DcManageConfig) GetStorageEtcDir() string {
+	return pathOrHome(c.Paths.StorageEtcDir, "etc")
+}
+
+func (c *DcManageConfig) GetProfilesDir() string {
+	return pathOrHome(c.Paths.ProfilesDir, "profiles")
+}
+
+// GetChainDataDir returns the data directory of dcchain
+func (c *DcManageConfig) GetChainDataDir() string {
+	return pathOrHome(c.ChainDataDir, "chaindata")
+}
+
+// GetProfileDir returns the directory holding the data of a profile
+func (c *DcManageConfig) GetProfileDir(profile string) string {
+	return filepath.Join(c.GetProfilesDir(), profile)
+}
+
+// Directory of a profile for a configured path: <path>/<profile> when the path is set,
+// otherwise <profilesDir>/<profile>/<name>
+func (c *DcManageConfig) profilePath(path string, profile string, name string) string {
+	if path != "" {
+		return filepath.Join(path, profile)
+	}
+	return filepath.Join(c.GetProfileDir(profile), name)
+}
+
+// GetProfileDataDir returns the state directory of dcmanager for a profile
+func (c *DcManageConfig) GetProfileDataDir(profile string) string {
+	return c.profilePath(c.Paths.DataDir, profile, "data")
+}
+
+// GetProfileDisksDir returns the storage disks directory of a profile
+func (c *DcManageConfig) GetProfileDisksDir(profile string) string {
+	return c.profilePath(c.Paths.DisksDir, profile, "disks")
+}
+
+// GetProfileStorageEtcDir returns the dcstorage config directory of a profile
+func (c *DcManageConfig) GetProfileStorageEtcDir(profile string) string {
+	return c.profilePath(c.Paths.StorageEtcDir, profile, "etc")
+}
+
+// Chain data directory of a profile that does not set chainDataDir,derived from the top level chainDataDir
+func (c *DcManageConfig) profileChainDataDir(profile string) string {
+	return c.profilePath(c.ChainDataDir, profile, "chaindata")
+}
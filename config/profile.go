@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ActiveProfile is the profile selected by the global --profile option, empty means the top level config (mainnet)
+var ActiveProfile string
+
+// NetworkProfile holds the settings of one network, e.g. testnet or a local devnet.
+// Its fields have the names of the DcManageConfig fields they override, empty fields take the top level value.
+type NetworkProfile struct {
+	ChainSpec          string    `yaml:"chainSpec,omitempty" json:"chainSpec,omitempty"` //--chain of dcchain: mainnet,testnet,dev or a chain spec file
+	ChainBootNode      string    `yaml:"chainBootNode,omitempty" json:"chainBootNode,omitempty"`
+	CommitBasePubkey   string    `yaml:"commitBasePubkey,omitempty" json:"commitBasePubkey,omitempty"`
+	Registry           string    `yaml:"registry,omitempty" json:"registry,omitempty"`
+	ChainImage         string    `yaml:"chainImage,omitempty" json:"chainImage,omitempty"`
+	NodeImage          string    `yaml:"nodeImage,omitempty" json:"nodeImage,omitempty"`
+	UpgradeImage       string    `yaml:"upgradeImage,omitempty" json:"upgradeImage,omitempty"`
+	ChainWsUrl         string    `yaml:"chainWsUrl,omitempty" json:"chainWsUrl,omitempty"`
+	ChainRpcListenPort int       `yaml:"chainRpcListenPort,omitempty" json:"chainRpcListenPort,omitempty"`
+	ChainP2pPort       int       `yaml:"chainP2pPort,omitempty" json:"chainP2pPort,omitempty"`
+	StorageListenPort  int       `yaml:"storageListenPort,omitempty" json:"storageListenPort,omitempty"`
+	UpgradeListenPort  int       `yaml:"upgradeListenPort,omitempty" json:"upgradeListenPort,omitempty"`
+	MetricsListenPort  int       `yaml:"metricsListenPort,omitempty" json:"metricsListenPort,omitempty"`
+	ChainDataDir       string    `yaml:"chainDataDir,omitempty" json:"chainDataDir,omitempty"` //default <chainDataDir>/<profile>,<profilesDir>/<profile>/chaindata without a top level chainDataDir
+	ChainNodeName      string    `yaml:"chainNodeName,omitempty" json:"chainNodeName,omitempty"`
+	ValidatorFlag      string    `yaml:"validatorFlag,omitempty" json:"validatorFlag,omitempty"`
+	ChainSyncMode      string    `yaml:"chainSyncMode,omitempty" json:"chainSyncMode,omitempty"`
+	ChainExposeFlag    string    `yaml:"chainExposeFlag,omitempty" json:"chainExposeFlag,omitempty"`
+	NewVersion         DcProgram `yaml:"newVersion,omitempty" json:"newVersion,omitempty"`
+}
+
+// Fields that belong to one network and are never taken from the top level config
+var profileOwnFields = map[string]bool{
+	"ValidatorFlag": true, //each network is configured by "dc --profile name config"
+	"NewVersion":    true,
+	"ChainDataDir":  true,
+}
+
+// The top level config as read from the config file, profile values are written back into it by SaveConfig
+var fileConfig *DcManageConfig
+
+// Names of the profiles defined in the config
+func (c *DcManageConfig) ProfileNames() (names []string) {
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// Return a copy of c with the values of the profile applied
+func (c *DcManageConfig) withProfile(profile string) (*DcManageConfig, error) {
+	p, ok := c.Profiles[profile]
+	if !ok || p == nil {
+		return nil, fmt.Errorf("profile %s is not defined in %s,defined profiles: %s", profile, Config_file_path, strings.Join(c.ProfileNames(), ","))
+	}
+	effective := *c
+	pv := reflect.ValueOf(p).Elem()
+	ev := reflect.ValueOf(&effective).Elem()
+	for i := 0; i < pv.NumField(); i++ {
+		if !pv.Field(i).IsZero() || profileOwnFields[pv.Type().Field(i).Name] {
+			ev.FieldByName(pv.Type().Field(i).Name).Set(pv.Field(i))
+		}
+	}
+	if p.ChainDataDir == "" { //never share the chain data with another network
+		effective.ChainDataDir = c.profileChainDataDir(profile)
+	}
+	return &effective, nil
+}
+
+// Split the effective config of the active profile back into the top level config and the profile.
+// A profile field is written when the profile already sets it,owns it or its value differs from the top level one.
+func splitProfile(effective *DcManageConfig) *DcManageConfig {
+	base := *fileConfig
+	base.Profiles = make(map[string]*NetworkProfile, len(fileConfig.Profiles))
+	for name, p := range fileConfig.Profiles {
+		base.Profiles[name] = p
+	}
+	profile := &NetworkProfile{}
+	if old := fileConfig.Profiles[ActiveProfile]; old != nil {
+		*profile = *old
+	}
+	ev := reflect.ValueOf(effective).Elem()
+	bv := reflect.ValueOf(&base).Elem()
+	pv := reflect.ValueOf(profile).Elem()
+	for i := 0; i < ev.NumField(); i++ {
+		name := ev.Type().Field(i).Name
+		if name == "Profiles" {
+			continue
+		}
+		pf := pv.FieldByName(name)
+		if !pf.IsValid() { //shared by all profiles
+			bv.Field(i).Set(ev.Field(i))
+			continue
+		}
+		if name == "ChainDataDir" && effective.ChainDataDir == fileConfig.profileChainDataDir(ActiveProfile) {
+			pf.Set(reflect.Zero(pf.Type())) //derived by withProfile,kept derived so that a later change of the top level paths still applies
+			continue
+		}
+		if profileOwnFields[name] || !pf.IsZero() || !reflect.DeepEqual(ev.Field(i).Interface(), bv.Field(i).Interface()) {
+			pf.Set(ev.Field(i))
+		}
+	}
+	base.Profiles[ActiveProfile] = profile
+	return &base
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestProfilePaths(t *testing.T) {
+	c := DefaultConfig()
+	c.Paths.ProfilesDir = "/profiles"
+	c.Profiles = map[string]*NetworkProfile{"testnet": {ChainSpec: "testnet"}}
+	if dir := c.GetProfileDataDir("testnet"); dir != filepath.Join("/profiles", "testnet", "data") {
+		t.Errorf("data dir %s without a configured dataDir", dir)
+	}
+	c.Paths.DataDir = "/data"
+	c.Paths.DisksDir = "/disks"
+	c.Paths.StorageEtcDir = "/etc/dcstorage"
+	if dir := c.GetProfileDataDir("testnet"); dir != filepath.Join("/data", "testnet") {
+		t.Errorf("data dir %s,want it under the configured dataDir", dir)
+	}
+	if dir := c.GetProfileDisksDir("testnet"); dir != filepath.Join("/disks", "testnet") {
+		t.Errorf("disks dir %s,want it under the configured disksDir", dir)
+	}
+	if dir := c.GetProfileStorageEtcDir("testnet"); dir != filepath.Join("/etc/dcstorage", "testnet") {
+		t.Errorf("etc dir %s,want it under the configured storageEtcDir", dir)
+	}
+
+	//The derived chain data dir must not be written to the profile,a later paths change has to apply
+	defer func(fc *DcManageConfig, profile string) { fileConfig, ActiveProfile = fc, profile }(fileConfig, ActiveProfile)
+	fileConfig, ActiveProfile = c, "testnet"
+	effective, err := c.withProfile("testnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if effective.ChainDataDir != filepath.Join("/profiles", "testnet", "chaindata") {
+		t.Fatalf("chain data dir %s", effective.ChainDataDir)
+	}
+	base := splitProfile(effective)
+	if dir := base.Profiles["testnet"].ChainDataDir; dir != "" {
+		t.Errorf("derived chain data dir %s written to the profile", dir)
+	}
+	base.ChainDataDir = "/chaindata"
+	if effective, _ = base.withProfile("testnet"); effective.ChainDataDir != filepath.Join("/chaindata", "testnet") {
+		t.Errorf("chain data dir %s after a change of the top level chainDataDir", effective.ChainDataDir)
+	}
+	effective.ChainDataDir = "/other"
+	if dir := splitProfile(effective).Profiles["testnet"].ChainDataDir; dir != "/other" {
+		t.Errorf("chain data dir %s set for the profile not written", dir)
+	}
+}
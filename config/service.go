@@ -0,0 +1,318 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	units "github.com/docker/go-units"
+)
+
+// Names of the managed services
+const (
+	ServiceStorage   = "storage"
+	ServiceChain     = "chain"
+	ServicePccs      = "pccs"
+	ServiceUpgrade   = "upgrade"
+	ServiceTeeReport = "teereport"
+)
+
+var ServiceNames = []string{ServicePccs, ServiceTeeReport, ServiceChain, ServiceStorage, ServiceUpgrade}
+
+// ServiceSpec declares how the container of a service is created.
+// dc builds a default spec for each service, the spec under services.<name> in the config overrides it field by field.
+type ServiceSpec struct {
+	Image         string       `yaml:"image,omitempty" json:"image,omitempty"`
+	Entrypoint    []string     `yaml:"entrypoint,omitempty" json:"entrypoint,omitempty"` //replaces the default entrypoint and its arguments
+	ExtraArgs     []string     `yaml:"extraArgs,omitempty" json:"extraArgs,omitempty"`   //appended to the entrypoint, e.g. [--rpc-methods, unsafe]
+	Env           []string     `yaml:"env,omitempty" json:"env,omitempty"`               //KEY=VALUE, replaces the default variable with the same KEY
+	Mounts        []MountSpec  `yaml:"mounts,omitempty" json:"mounts,omitempty"`         //added to the default mounts, replaces the one with the same target
+	Devices       []string     `yaml:"devices,omitempty" json:"devices,omitempty"`       //host[:container[:permissions]], replaces the default devices
+	NetworkMode   string       `yaml:"networkMode,omitempty" json:"networkMode,omitempty"`
+	Ports         []string     `yaml:"ports,omitempty" json:"ports,omitempty"` //[ip:]hostPort:containerPort[/proto], only used without host network
+	RestartPolicy string       `yaml:"restartPolicy,omitempty" json:"restartPolicy,omitempty"`
+	Resources     ResourceSpec `yaml:"resources,omitempty" json:"resources,omitempty"`
+	Log           LogSpec      `yaml:"log,omitempty" json:"log,omitempty"`
+	Healthcheck   *HealthSpec  `yaml:"healthcheck,omitempty" json:"healthcheck,omitempty"`
+	StartTimeout  string       `yaml:"startTimeout,omitempty" json:"startTimeout,omitempty"` //time dc start waits for the service to be ready before starting the services depending on it, e.g. 5m
+}
+
+// MountSpec is a volume or a host directory mounted into the container
+type MountSpec struct {
+	Type        string `yaml:"type" json:"type"` //bind or volume
+	Source      string `yaml:"source" json:"source"`
+	Target      string `yaml:"target" json:"target"`
+	ReadOnly    bool   `yaml:"readOnly,omitempty" json:"readOnly,omitempty"`
+	Propagation string `yaml:"propagation,omitempty" json:"propagation,omitempty"` //bind propagation, e.g. shared
+}
+
+// ResourceSpec limits the resources of the container
+type ResourceSpec struct {
+	Memory     string      `yaml:"memory,omitempty" json:"memory,omitempty"`         //e.g. 8g
+	MemorySwap string      `yaml:"memorySwap,omitempty" json:"memorySwap,omitempty"` //memory plus swap, e.g. 10g, -1 for unlimited swap
+	Cpus       float64     `yaml:"cpus,omitempty" json:"cpus,omitempty"`             //number of cpus, e.g. 1.5
+	CpuShares  int64       `yaml:"cpuShares,omitempty" json:"cpuShares,omitempty"`   //relative cpu weight, docker default 1024
+	CpuQuota   int64       `yaml:"cpuQuota,omitempty" json:"cpuQuota,omitempty"`     //microseconds of cpu time per cpuPeriod, instead of cpus
+	CpuPeriod  int64       `yaml:"cpuPeriod,omitempty" json:"cpuPeriod,omitempty"`   //microseconds, docker default 100000
+	PidsLimit  int64       `yaml:"pidsLimit,omitempty" json:"pidsLimit,omitempty"`   //max number of processes, -1 for unlimited
+	Nofile     *UlimitSpec `yaml:"nofile,omitempty" json:"nofile,omitempty"`         //max number of open files
+}
+
+// UlimitSpec is a soft and hard ulimit
+type UlimitSpec struct {
+	Soft int64 `yaml:"soft" json:"soft"`
+	Hard int64 `yaml:"hard" json:"hard"`
+}
+
+// LogSpec is the log driver of the container
+type LogSpec struct {
+	Driver  string            `yaml:"driver,omitempty" json:"driver,omitempty"`
+	Options map[string]string `yaml:"options,omitempty" json:"options,omitempty"`
+}
+
+// HealthSpec is the docker healthcheck of the container
+type HealthSpec struct {
+	Test        []string `yaml:"test,omitempty" json:"test,omitempty"`               //e.g. [CMD-SHELL, "curl -fsS http://127.0.0.1:6667/version"], [NONE] disables the check
+	Interval    string   `yaml:"interval,omitempty" json:"interval,omitempty"`       //time between two checks, e.g. 30s
+	Timeout     string   `yaml:"timeout,omitempty" json:"timeout,omitempty"`         //time a check may take
+	StartPeriod string   `yaml:"startPeriod,omitempty" json:"startPeriod,omitempty"` //failures in this time after the start are not counted
+	Retries     int      `yaml:"retries,omitempty" json:"retries,omitempty"`         //consecutive failures before the container is unhealthy
+}
+
+// Merge applies the override o to the spec s
+func (s *ServiceSpec) Merge(o *ServiceSpec) {
+	if o == nil {
+		return
+	}
+	if o.Image != "" {
+		s.Image = o.Image
+	}
+	if len(o.Entrypoint) > 0 {
+		s.Entrypoint = o.Entrypoint
+	}
+	s.ExtraArgs = append(s.ExtraArgs, o.ExtraArgs...)
+	for _, env := range o.Env {
+		key := strings.SplitN(env, "=", 2)[0]
+		replaced := false
+		for i := range s.Env {
+			if strings.SplitN(s.Env[i], "=", 2)[0] == key {
+				s.Env[i] = env
+				replaced = true
+			}
+		}
+		if !replaced {
+			s.Env = append(s.Env, env)
+		}
+	}
+	for _, m := range o.Mounts {
+		replaced := false
+		for i := range s.Mounts {
+			if s.Mounts[i].Target == m.Target {
+				s.Mounts[i] = m
+				replaced = true
+			}
+		}
+		if !replaced {
+			s.Mounts = append(s.Mounts, m)
+		}
+	}
+	if len(o.Devices) > 0 {
+		s.Devices = o.Devices
+	}
+	if o.NetworkMode != "" {
+		s.NetworkMode = o.NetworkMode
+	}
+	if len(o.Ports) > 0 {
+		s.Ports = o.Ports
+	}
+	if o.RestartPolicy != "" {
+		s.RestartPolicy = o.RestartPolicy
+	}
+	if o.Resources.Memory != "" {
+		s.Resources.Memory = o.Resources.Memory
+	}
+	if o.Resources.MemorySwap != "" {
+		s.Resources.MemorySwap = o.Resources.MemorySwap
+	}
+	if o.Resources.Cpus != 0 {
+		s.Resources.Cpus = o.Resources.Cpus
+	}
+	if o.Resources.CpuShares != 0 {
+		s.Resources.CpuShares = o.Resources.CpuShares
+	}
+	if o.Resources.CpuQuota != 0 {
+		s.Resources.CpuQuota = o.Resources.CpuQuota
+	}
+	if o.Resources.CpuPeriod != 0 {
+		s.Resources.CpuPeriod = o.Resources.CpuPeriod
+	}
+	if o.Resources.PidsLimit != 0 {
+		s.Resources.PidsLimit = o.Resources.PidsLimit
+	}
+	if o.Resources.Nofile != nil {
+		s.Resources.Nofile = o.Resources.Nofile
+	}
+	if o.Log.Driver != "" {
+		s.Log = LogSpec{Driver: o.Log.Driver}
+	}
+	if o.StartTimeout != "" {
+		s.StartTimeout = o.StartTimeout
+	}
+	if o.Healthcheck != nil {
+		health := HealthSpec{}
+		if s.Healthcheck != nil {
+			health = *s.Healthcheck
+		}
+		if len(o.Healthcheck.Test) > 0 {
+			health.Test = o.Healthcheck.Test
+		}
+		if o.Healthcheck.Interval != "" {
+			health.Interval = o.Healthcheck.Interval
+		}
+		if o.Healthcheck.Timeout != "" {
+			health.Timeout = o.Healthcheck.Timeout
+		}
+		if o.Healthcheck.StartPeriod != "" {
+			health.StartPeriod = o.Healthcheck.StartPeriod
+		}
+		if o.Healthcheck.Retries != 0 {
+			health.Retries = o.Healthcheck.Retries
+		}
+		s.Healthcheck = &health
+	}
+	if len(o.Log.Options) > 0 {
+		options := map[string]string{}
+		for k, v := range s.Log.Options {
+			options[k] = v
+		}
+		for k, v := range o.Log.Options {
+			options[k] = v
+		}
+		s.Log.Options = options
+	}
+}
+
+func (s *ServiceSpec) validate(field string, verr *ValidationError) {
+	for i, env := range s.Env {
+		if !strings.Contains(env, "=") {
+			verr.add(fmt.Sprintf("%s.env[%d]", field, i), env, "must be KEY=VALUE")
+		}
+	}
+	for i, m := range s.Mounts {
+		mfield := fmt.Sprintf("%s.mounts[%d]", field, i)
+		if m.Type != "bind" && m.Type != "volume" {
+			verr.add(mfield+".type", m.Type, "must be bind or volume")
+		}
+		if m.Source == "" {
+			verr.add(mfield+".source", m.Source, "is required")
+		}
+		if !strings.HasPrefix(m.Target, "/") {
+			verr.add(mfield+".target", m.Target, "must be an absolute path in the container")
+		}
+	}
+	for i, d := range s.Devices {
+		if !strings.HasPrefix(d, "/") {
+			verr.add(fmt.Sprintf("%s.devices[%d]", field, i), d, "must be host[:container[:permissions]]")
+		}
+	}
+	if s.RestartPolicy != "" && !oneOf(strings.SplitN(s.RestartPolicy, ":", 2)[0], []string{"no", "always", "unless-stopped", "on-failure"}) {
+		verr.add(field+".restartPolicy", s.RestartPolicy, "must be no,always,unless-stopped or on-failure[:max-retries]")
+	}
+	s.Resources.validate(field+".resources", verr)
+	if s.StartTimeout != "" {
+		if d, err := time.ParseDuration(s.StartTimeout); err != nil || d <= 0 {
+			verr.add(field+".startTimeout", s.StartTimeout, "must be a duration like 90s or 5m")
+		}
+	}
+	if h := s.Healthcheck; h != nil {
+		if len(h.Test) > 0 && !oneOf(h.Test[0], []string{"NONE", "CMD", "CMD-SHELL"}) {
+			verr.add(field+".healthcheck.test", h.Test[0], "must start with NONE,CMD or CMD-SHELL")
+		}
+		durations := []struct{ name, value string }{{"interval", h.Interval}, {"timeout", h.Timeout}, {"startPeriod", h.StartPeriod}}
+		for _, d := range durations {
+			if d.value == "" {
+				continue
+			}
+			if duration, err := time.ParseDuration(d.value); err != nil || duration < time.Millisecond {
+				verr.add(field+".healthcheck."+d.name, d.value, "must be a duration like 30s or 2m")
+			}
+		}
+		if h.Retries < 0 {
+			verr.add(field+".healthcheck.retries", h.Retries, "must not be negative")
+		}
+	}
+}
+
+func (r *ResourceSpec) validate(field string, verr *ValidationError) {
+	var memory int64
+	if r.Memory != "" {
+		var err error
+		if memory, err = units.RAMInBytes(r.Memory); err != nil {
+			verr.add(field+".memory", r.Memory, "must be a size like 512m or 8g")
+		}
+	}
+	if r.MemorySwap != "" && r.MemorySwap != "-1" {
+		swap, err := units.RAMInBytes(r.MemorySwap)
+		if err != nil {
+			verr.add(field+".memorySwap", r.MemorySwap, "must be a size like 10g, or -1 for unlimited swap")
+		} else if memory == 0 {
+			verr.add(field+".memorySwap", r.MemorySwap, "needs memory to be set")
+		} else if swap < memory {
+			verr.add(field+".memorySwap", r.MemorySwap, "must not be less than memory")
+		}
+	}
+	if r.Cpus < 0 {
+		verr.add(field+".cpus", r.Cpus, "must not be negative")
+	}
+	if r.CpuShares < 0 {
+		verr.add(field+".cpuShares", r.CpuShares, "must not be negative")
+	}
+	if r.CpuQuota != 0 && r.CpuQuota < 1000 {
+		verr.add(field+".cpuQuota", r.CpuQuota, "must be at least 1000 microseconds")
+	}
+	if r.CpuPeriod != 0 && (r.CpuPeriod < 1000 || r.CpuPeriod > 1000000) {
+		verr.add(field+".cpuPeriod", r.CpuPeriod, "must be between 1000 and 1000000 microseconds")
+	}
+	if r.Cpus > 0 && (r.CpuQuota != 0 || r.CpuPeriod != 0) {
+		verr.add(field+".cpus", r.Cpus, "can not be used together with cpuQuota or cpuPeriod")
+	}
+	if r.PidsLimit < -1 {
+		verr.add(field+".pidsLimit", r.PidsLimit, "must be a positive number, or -1 for unlimited")
+	}
+	if r.Nofile != nil && (r.Nofile.Soft <= 0 || r.Nofile.Hard < r.Nofile.Soft) {
+		verr.add(field+".nofile", fmt.Sprintf("%d:%d", r.Nofile.Soft, r.Nofile.Hard), "soft must be positive and not more than hard")
+	}
+}
+
+// String lists the limits that are set, e.g. "memory=16GiB cpus=2 nofile=65535:65535"
+func (r *ResourceSpec) String() string {
+	limits := []string{}
+	if r.Memory != "" {
+		limits = append(limits, "memory="+r.Memory)
+	}
+	if r.MemorySwap != "" {
+		limits = append(limits, "memorySwap="+r.MemorySwap)
+	}
+	if r.Cpus != 0 {
+		limits = append(limits, fmt.Sprintf("cpus=%g", r.Cpus))
+	}
+	if r.CpuShares != 0 {
+		limits = append(limits, fmt.Sprintf("cpuShares=%d", r.CpuShares))
+	}
+	if r.CpuQuota != 0 {
+		limits = append(limits, fmt.Sprintf("cpuQuota=%d", r.CpuQuota))
+	}
+	if r.CpuPeriod != 0 {
+		limits = append(limits, fmt.Sprintf("cpuPeriod=%d", r.CpuPeriod))
+	}
+	if r.PidsLimit != 0 {
+		limits = append(limits, fmt.Sprintf("pidsLimit=%d", r.PidsLimit))
+	}
+	if r.Nofile != nil {
+		limits = append(limits, fmt.Sprintf("nofile=%d:%d", r.Nofile.Soft, r.Nofile.Hard))
+	}
+	if len(limits) == 0 {
+		return "none"
+	}
+	return strings.Join(limits, " ")
+}
@@ -0,0 +1,233 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	mbase "github.com/multiformats/go-multibase"
+)
+
+// Accepted values of the enumerated config fields
+var (
+	ChainSyncModes = []string{"full", "fast", "fast-unsafe", "warp"}
+	EnableFlags    = []string{"enable", "disable"}
+	WeekDays       = []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"}
+	LogFormats     = []string{"text", "json"}
+	LogLevels      = []string{"debug", "info", "warn", "error"}
+)
+
+var profileNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`) //profile names are part of container names
+
+const PccsKeyMinLength = 32 //Length of an intel pccs subscription key
+
+// FieldError is a config field holding an invalid value, Field is the yaml path of the field
+type FieldError struct {
+	Field   string
+	Value   interface{}
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s (value: %v)", e.Field, e.Message, e.Value)
+}
+
+// Under reports whether the field is key or a field or element below it,e.g. upgradePolicy.windows[0].days for upgradePolicy.windows
+func (e *FieldError) Under(key string) bool {
+	return e.Field == key || strings.HasPrefix(e.Field, key+".") || strings.HasPrefix(e.Field, key+"[")
+}
+
+// ValidationError lists all invalid fields of a config
+type ValidationError []*FieldError
+
+func (v ValidationError) Error() string {
+	msgs := make([]string, 0, len(v))
+	for _, e := range v {
+		msgs = append(msgs, e.Error())
+	}
+	return "invalid config:\n  " + strings.Join(msgs, "\n  ")
+}
+
+// Fields returns the names of the invalid fields
+func (v ValidationError) Fields() []string {
+	fields := make([]string, 0, len(v))
+	for _, e := range v {
+		fields = append(fields, e.Field)
+	}
+	return fields
+}
+
+func (v *ValidationError) add(field string, value interface{}, format string, args ...interface{}) {
+	*v = append(*v, &FieldError{Field: field, Value: value, Message: fmt.Sprintf(format, args...)})
+}
+
+func oneOf(value string, values []string) bool {
+	for _, v := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the values of all fields, the returned error is a ValidationError listing every invalid field.
+// Fields not configured yet by "dc config" (validatorFlag,chainSyncMode,chainExposeFlag,pccsKey) may be empty.
+func (c *DcManageConfig) Validate() error {
+	var verr ValidationError
+	if c.ValidatorFlag != "" && !oneOf(c.ValidatorFlag, EnableFlags) {
+		verr.add("validatorFlag", c.ValidatorFlag, "must be one of %s", strings.Join(EnableFlags, ","))
+	}
+	if c.ChainExposeFlag != "" && !oneOf(c.ChainExposeFlag, EnableFlags) {
+		verr.add("chainExposeFlag", c.ChainExposeFlag, "must be one of %s", strings.Join(EnableFlags, ","))
+	}
+	if c.ChainSyncMode != "" && !oneOf(c.ChainSyncMode, ChainSyncModes) {
+		verr.add("chainSyncMode", c.ChainSyncMode, "unknown sync mode,must be one of %s", strings.Join(ChainSyncModes, ","))
+	}
+	if u, err := url.Parse(c.ChainWsUrl); err != nil {
+		verr.add("chainWsUrl", c.ChainWsUrl, "malformed url: %v", err)
+	} else if u.Scheme != "ws" && u.Scheme != "wss" {
+		verr.add("chainWsUrl", c.ChainWsUrl, "scheme must be ws or wss")
+	} else if u.Hostname() == "" {
+		verr.add("chainWsUrl", c.ChainWsUrl, "host is missing")
+	}
+	ports := []struct {
+		field string
+		value int
+	}{
+		{"chainRpcListenPort", c.ChainRpcListenPort},
+		{"chainP2pPort", c.ChainP2pPort},
+		{"storageListenPort", c.StorageListenPort},
+		{"upgradeListenPort", c.UpgradeListenPort},
+	}
+	for _, port := range ports {
+		if port.value < 1 || port.value > 65535 {
+			verr.add(port.field, port.value, "port must be in 1-65535")
+		}
+	}
+	if c.MetricsListenPort < 0 || c.MetricsListenPort > 65535 {
+		verr.add("metricsListenPort", c.MetricsListenPort, "port must be in 1-65535,or 0 to disable")
+	}
+	if net.ParseIP(c.MetricsListenAddr) == nil {
+		verr.add("metricsListenAddr", c.MetricsListenAddr, "must be an ip address,e.g. 127.0.0.1,or 0.0.0.0 for all interfaces")
+	}
+	if c.StorageQuiesceTimeout < 0 {
+		verr.add("storageQuiesceTimeout", c.StorageQuiesceTimeout, "must not be negative,0 stops dcstorage without flush")
+	}
+	c.Logging.validate(&verr)
+	if c.PccsKey != "" && len(c.PccsKey) < PccsKeyMinLength {
+		verr.add("pccsKey", strings.Repeat("*", len(c.PccsKey)), "must be at least %d characters", PccsKeyMinLength)
+	}
+	images := []struct {
+		field string
+		value string
+	}{
+		{"chainImage", c.ChainImage},
+		{"nodeImage", c.NodeImage},
+		{"upgradeImage", c.UpgradeImage},
+		{"teeReportServerImage", c.TeeReportServerImage},
+		{"pccsImage", c.PccsImage},
+	}
+	for _, image := range images {
+		if image.value == "" || strings.ContainsAny(image.value, " \t") {
+			verr.add(image.field, image.value, "must be an image reference like ghcr.io/dcnetio/name:tag")
+		}
+	}
+	if c.ChainSpec == "" {
+		verr.add("chainSpec", c.ChainSpec, "must be a chain name like mainnet or a chain spec file")
+	}
+	if _, _, err := mbase.Decode(c.CommitBasePubkey); err != nil {
+		verr.add("commitBasePubkey", c.CommitBasePubkey, "must be a multibase encoded pubkey: %v", err)
+	}
+	paths := []struct {
+		field string
+		value string
+	}{
+		{"chainDataDir", c.ChainDataDir},
+		{"paths.logFile", c.Paths.LogFile},
+		{"paths.dataDir", c.Paths.DataDir},
+		{"paths.disksDir", c.Paths.DisksDir},
+		{"paths.storageEtcDir", c.Paths.StorageEtcDir},
+		{"paths.profilesDir", c.Paths.ProfilesDir},
+	}
+	for _, path := range paths {
+		if path.value != "" && !filepath.IsAbs(path.value) {
+			verr.add(path.field, path.value, "must be an absolute path,or empty for the default under %s", HomeDir)
+		}
+	}
+	for name := range c.Profiles {
+		if !profileNamePattern.MatchString(name) {
+			verr.add("profiles."+name, name, "profile name may only contain a-z,0-9,'_','.' and '-'")
+		}
+	}
+	for name, spec := range c.Services {
+		field := "services." + name
+		if !oneOf(name, ServiceNames) {
+			verr.add(field, name, "unknown service,must be one of %s", strings.Join(ServiceNames, ","))
+			continue
+		}
+		if spec == nil {
+			continue
+		}
+		if name == ServiceStorage && spec.Image != "" {
+			verr.add(field+".image", spec.Image, "the dcstorage image is managed by the upgrade,set nodeImage instead")
+		}
+		spec.validate(field, &verr)
+	}
+	c.UpgradePolicy.validate(&verr)
+	if c.NewVersion.Version != "" && c.NewVersion.EnclaveId == "" {
+		verr.add("newVersion.enclaveId", c.NewVersion.EnclaveId, "is required when newVersion.version is set")
+	}
+	if len(verr) > 0 {
+		return verr
+	}
+	return nil
+}
+
+func (p *UpgradePolicy) validate(verr *ValidationError) {
+	if p.Timezone != "" {
+		if _, err := time.LoadLocation(p.Timezone); err != nil {
+			verr.add("upgradePolicy.timezone", p.Timezone, "unknown timezone")
+		}
+	}
+	if p.RolloutDelayMax < 0 {
+		verr.add("upgradePolicy.rolloutDelayMax", p.RolloutDelayMax, "must not be negative")
+	}
+	for i, w := range p.Windows {
+		field := fmt.Sprintf("upgradePolicy.windows[%d]", i)
+		if w.StartHour < 0 || w.StartHour > 23 {
+			verr.add(field+".startHour", w.StartHour, "must be in 0-23")
+		}
+		if w.EndHour < 1 || w.EndHour > 24 {
+			verr.add(field+".endHour", w.EndHour, "must be in 1-24")
+		}
+		for j, d := range w.Days {
+			if !oneOf(strings.ToLower(d), WeekDays) {
+				verr.add(fmt.Sprintf("%s.days[%d]", field, j), d, "must be one of %s", strings.Join(WeekDays, ","))
+			}
+		}
+	}
+}
+
+func (l *LogConfig) validate(verr *ValidationError) {
+	if !oneOf(l.Format, LogFormats) {
+		verr.add("logging.format", l.Format, "must be one of %s", strings.Join(LogFormats, ","))
+	}
+	if !oneOf(l.Level, LogLevels) {
+		verr.add("logging.level", l.Level, "must be one of %s", strings.Join(LogLevels, ","))
+	}
+	if l.MaxSize < 0 {
+		verr.add("logging.maxSize", l.MaxSize, "must not be negative,0 disables rotation by size")
+	}
+	if l.MaxAge != "" {
+		if d, err := time.ParseDuration(l.MaxAge); err != nil || d < time.Minute {
+			verr.add("logging.maxAge", l.MaxAge, "must be a duration of at least 1m like 24h,or empty to disable rotation by age")
+		}
+	}
+	if l.MaxBackups < 0 {
+		verr.add("logging.maxBackups", l.MaxBackups, "must not be negative,0 keeps all rotated files")
+	}
+}
@@ -0,0 +1,25 @@
+package config
+
+import "testing"
+
+func TestFieldErrorUnder(t *testing.T) {
+	tests := []struct {
+		field string
+		key   string
+		want  bool
+	}{
+		{field: "logging.level", key: "logging.level", want: true},
+		{field: "logging.level", key: "logging", want: true},
+		{field: "upgradePolicy.windows[0].days[1]", key: "upgradePolicy.windows", want: true},
+		{field: "metricsListenAddr", key: "metricsListenAddr", want: true},
+		{field: "metricsListenAddr", key: "metricsListen"},
+		{field: "chainWsUrl", key: "chain"},
+		{field: "services.dcchain.image", key: "services.dc"},
+	}
+	for _, tt := range tests {
+		e := &FieldError{Field: tt.field}
+		if got := e.Under(tt.key); got != tt.want {
+			t.Errorf("FieldError{%s}.Under(%s) = %v,want %v", tt.field, tt.key, got, tt.want)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/dcnetio/dc/command"
 	"github.com/dcnetio/dc/config"
@@ -12,32 +13,55 @@ import (
 
 var log = logging.Logger("dcmanager")
 
-// logpath := "./log"
-const logpath = "/opt/dcnetio/log"
-
 func main() {
-	util.SetupDefaultLoggingConfig(logpath)
+	//Global options select the home directory and profile, so they are applied before reading the configuration
+	if err := command.ParseGlobalOptions(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	util.SetupDefaultLoggingConfig(config.RunningConfig.GetLogFile())
 	//Determine whether the configuration file exists
 	_, err := os.Stat(config.Config_file_path)
 	if err != nil {
 		if os.IsNotExist(err) { //File does not exist, update default configuration to configuration file
 			//Create a directory
 			if err = config.SaveConfig(config.RunningConfig); err != nil {
+				fmt.Println(err)
 				return
 			}
 		} else {
+			fmt.Println(err)
 			return
 		}
 	}
 	//Read configuration file
-	if config.RunningConfig, err = config.ReadConfig(); err != nil {
-		log.Fatalf("read config file fail,err: %v", err)
-		return
+	localConfig, err := config.ReadConfig()
+	if localConfig == nil {
+		fmt.Println(err)
+		log.Errorf("read config file fail,err: %v", err)
+		os.Exit(1)
+	}
+	config.RunningConfig = localConfig
+	if err != nil { //Invalid values,only the config command is allowed to fix them
+		fmt.Println(err)
+		//The daemon keeps running and reports the invalid fields,so that a bad value does not crash loop dc.service. dc status shows them.
+		if len(os.Args) < 2 || (os.Args[1] != "config" && os.Args[1] != "pccs_api_key" && os.Args[1] != "status" && !(len(os.Args) > 2 && os.Args[1] == "upgrade" && os.Args[2] == "daemon")) {
+			fmt.Printf("please fix %s or reconfigure with command:  dc config\n", config.Config_file_path)
+			os.Exit(1)
+		}
+	}
+	if err = command.SetupProfile(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err = command.SetupLogging(); err != nil {
+		fmt.Printf("setup log file fail,err: %v\n", err)
 	}
 	//Determine whether the chain node name is empty. If it is empty, generate a random chain node name.
 	if config.RunningConfig.ChainNodeName == "" {
 		config.RunningConfig.ChainNodeName = "dcnet_" + util.RandStringBytes(12)
 		if err = config.SaveConfig(config.RunningConfig); err != nil {
+			fmt.Println(err)
 			return
 		}
 	}
@@ -48,7 +72,7 @@ func main() {
 	}
 	//Determine whether the verification node has been configured to open. If it is not configured, it prompts for configuration.
 	if config.RunningConfig.ValidatorFlag == "" && os.Args[1] != "config" {
-		fmt.Println("please config chain first,use command:  dc config")
+		fmt.Printf("please config chain first,use command:  %s config\n", strings.Join(append([]string{"dc"}, command.GlobalArgs()...), " "))
 		os.Exit(0) //exit the program
 	}
 
@@ -59,10 +83,16 @@ func main() {
 		command.StartCommandDeal()
 	case "stop":
 		command.StopCommandDeal()
+	case "restart":
+		command.RestartCommandDeal()
+	case "recreate":
+		command.RecreateCommandDeal()
 	case "status":
 		command.StatusCommandDeal()
 	case "log":
 		command.LogCommandDeal()
+	case "events":
+		command.EventsCommandDeal()
 	case "upgrade":
 		command.UpgradeCommandDeal()
 	case "uniqueid":
@@ -84,5 +114,6 @@ func main() {
 	default:
 		command.ShowHelp()
 	}
+	util.CloseContainerRuntime()
 	os.Exit(1)
 }
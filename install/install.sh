@@ -108,6 +108,7 @@ if [  -f $installetcdir/manage_config.yaml ]; then
     else
         sudo cp -rf $localbasedir/etc/dc.bash_completion $installetcdir
         sudo cp -rf $localbasedir/etc/dc.service $installetcdir
+        sudo cp -rf $localbasedir/etc/dc@.service $installetcdir
     fi
 else
     sudo cp -rf $localbasedir/etc/* $installetcdir
@@ -142,6 +143,7 @@ sudo cp $installetcdir/dc.bash_completion /etc/bash_completion.d/dc
 sudo chmod +x /etc/bash_completion.d/dc
 #set systemd service
 sudo cp $installetcdir/dc.service /etc/systemd/system/dc.service
+sudo cp $installetcdir/dc@.service /etc/systemd/system/dc@.service
 sudo chmod +x /etc/systemd/system/dc.service
 sudo systemctl daemon-reload
 sudo systemctl enable dc.service
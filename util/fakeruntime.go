@@ -0,0 +1,301 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// FakeContainer is a container held by FakeRuntime
+type FakeContainer struct {
+	ID           string
+	Name         string
+	Config       *container.Config
+	HostConfig   *container.HostConfig
+	Running      bool
+	StartedAt    time.Time
+	RestartCount int
+	ExitCode     int
+	OOMKilled    bool
+	Health       string //healthcheck status,empty without healthcheck
+	Logs         []byte //stdout of the container
+}
+
+// FakeRuntime is an in-memory ContainerRuntime, used to exercise container flows without a docker daemon.
+// Errors can be injected per method name (e.g. "ContainerStart") through Errs.
+type FakeRuntime struct {
+	mu         sync.Mutex
+	nextId     int
+	Containers map[string]*FakeContainer //key: container id
+	Volumes    map[string]*volume.Volume //key: volume name
+	Images     map[string]bool           //pulled or loaded images
+	Manifests  map[string]string         //images resolvable in the registry, key: image, value: digest
+	Errs       map[string]error          //key: method name
+	Calls      []string                  //method calls in order, e.g. "ContainerStop dcstorage"
+}
+
+// NewFakeRuntime creates an empty in-memory runtime
+func NewFakeRuntime() *FakeRuntime {
+	return &FakeRuntime{
+		Containers: make(map[string]*FakeContainer),
+		Volumes:    make(map[string]*volume.Volume),
+		Images:     make(map[string]bool),
+		Manifests:  make(map[string]string),
+		Errs:       make(map[string]error),
+	}
+}
+
+// record the call and return the injected error of the method if any. f.mu must be held
+func (f *FakeRuntime) call(method string, arg string) error {
+	f.Calls = append(f.Calls, method+" "+arg)
+	return f.Errs[method]
+}
+
+// find container by id or name. f.mu must be held
+func (f *FakeRuntime) lookup(idOrName string) (*FakeContainer, error) {
+	if c, ok := f.Containers[idOrName]; ok {
+		return c, nil
+	}
+	for _, c := range f.Containers {
+		if c.Name == idOrName || "/"+c.Name == idOrName {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("Error: No such container: %s", idOrName)
+}
+
+// Container returns the container with the given name, or nil
+func (f *FakeRuntime) Container(containerName string) *FakeContainer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, _ := f.lookup(containerName)
+	return c
+}
+
+func (f *FakeRuntime) ContainerList(ctx context.Context, all bool) ([]types.Container, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("ContainerList", ""); err != nil {
+		return nil, err
+	}
+	containers := []types.Container{}
+	for _, c := range f.Containers {
+		if !all && !c.Running {
+			continue
+		}
+		state := "exited"
+		if c.Running {
+			state = "running"
+		}
+		containers = append(containers, types.Container{
+			ID:    c.ID,
+			Names: []string{"/" + c.Name},
+			Image: c.Config.Image,
+			State: state,
+		})
+	}
+	sort.Slice(containers, func(i, j int) bool { return containers[i].ID < containers[j].ID })
+	return containers, nil
+}
+
+func (f *FakeRuntime) ContainerInspect(ctx context.Context, containerId string) (types.ContainerJSON, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("ContainerInspect", containerId); err != nil {
+		return types.ContainerJSON{}, err
+	}
+	c, err := f.lookup(containerId)
+	if err != nil {
+		return types.ContainerJSON{}, err
+	}
+	state := &types.ContainerState{
+		Running:   c.Running,
+		ExitCode:  c.ExitCode,
+		OOMKilled: c.OOMKilled,
+	}
+	if c.Health != "" {
+		state.Health = &types.Health{Status: c.Health}
+	}
+	if c.Running {
+		state.Status = "running"
+		state.StartedAt = c.StartedAt.Format(time.RFC3339Nano)
+	} else {
+		state.Status = "exited"
+	}
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:           c.ID,
+			Name:         "/" + c.Name,
+			Image:        c.Config.Image,
+			State:        state,
+			RestartCount: c.RestartCount,
+			HostConfig:   c.HostConfig,
+		},
+		Config: c.Config,
+	}, nil
+}
+
+func (f *FakeRuntime) ContainerCreate(ctx context.Context, containerName string, config *container.Config, hostConfig *container.HostConfig) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("ContainerCreate", containerName); err != nil {
+		return "", err
+	}
+	if c, err := f.lookup(containerName); err == nil {
+		return "", fmt.Errorf("Error response from daemon: Conflict. The container name \"/%s\" is already in use by container \"%s\". You have to remove (or rename) that container to be able to reuse that name.", containerName, c.ID)
+	}
+	f.nextId++
+	id := fmt.Sprintf("%064x", f.nextId)
+	f.Containers[id] = &FakeContainer{
+		ID:         id,
+		Name:       containerName,
+		Config:     config,
+		HostConfig: hostConfig,
+	}
+	return id, nil
+}
+
+func (f *FakeRuntime) ContainerStart(ctx context.Context, containerId string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("ContainerStart", containerId); err != nil {
+		return err
+	}
+	c, err := f.lookup(containerId)
+	if err != nil {
+		return err
+	}
+	if !c.Running {
+		c.Running = true
+		c.StartedAt = time.Now()
+	}
+	return nil
+}
+
+func (f *FakeRuntime) ContainerStop(ctx context.Context, containerId string, waitTimeout *int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("ContainerStop", containerId); err != nil {
+		return err
+	}
+	c, err := f.lookup(containerId)
+	if err != nil {
+		return err
+	}
+	c.Running = false
+	return nil
+}
+
+func (f *FakeRuntime) ContainerRemove(ctx context.Context, containerId string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("ContainerRemove", containerId); err != nil {
+		return err
+	}
+	c, err := f.lookup(containerId)
+	if err != nil {
+		return err
+	}
+	delete(f.Containers, c.ID)
+	return nil
+}
+
+func (f *FakeRuntime) ContainerLogs(ctx context.Context, containerId string, options types.ContainerLogsOptions) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("ContainerLogs", containerId); err != nil {
+		return nil, err
+	}
+	c, err := f.lookup(containerId)
+	if err != nil {
+		return nil, err
+	}
+	//docker multiplexes stdout and stderr of containers without tty
+	stream := &bytes.Buffer{}
+	stdcopy.NewStdWriter(stream, stdcopy.Stdout).Write(c.Logs)
+	return io.NopCloser(stream), nil
+}
+
+func (f *FakeRuntime) VolumeList(ctx context.Context) ([]*volume.Volume, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("VolumeList", ""); err != nil {
+		return nil, err
+	}
+	volumes := []*volume.Volume{}
+	for _, v := range f.Volumes {
+		volumes = append(volumes, v)
+	}
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].Name < volumes[j].Name })
+	return volumes, nil
+}
+
+func (f *FakeRuntime) VolumeCreate(ctx context.Context, volumeName string) (volume.Volume, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("VolumeCreate", volumeName); err != nil {
+		return volume.Volume{}, err
+	}
+	v, ok := f.Volumes[volumeName]
+	if !ok {
+		v = &volume.Volume{Name: volumeName, Driver: "local"}
+		f.Volumes[volumeName] = v
+	}
+	return *v, nil
+}
+
+func (f *FakeRuntime) VolumeRemove(ctx context.Context, volumeName string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("VolumeRemove", volumeName); err != nil {
+		return err
+	}
+	delete(f.Volumes, volumeName)
+	return nil
+}
+
+func (f *FakeRuntime) ImagePull(ctx context.Context, image string) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("ImagePull", image); err != nil {
+		return nil, err
+	}
+	f.Images[image] = true
+	return io.NopCloser(bytes.NewReader(nil)), nil
+}
+
+func (f *FakeRuntime) ImageLoad(ctx context.Context, input io.Reader) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("ImageLoad", ""); err != nil {
+		return err
+	}
+	_, err := io.Copy(io.Discard, input)
+	return err
+}
+
+func (f *FakeRuntime) ImageManifestDigest(ctx context.Context, image string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("ImageManifestDigest", image); err != nil {
+		return "", err
+	}
+	digest, ok := f.Manifests[image]
+	if !ok {
+		return "", fmt.Errorf("Error response from daemon: manifest unknown: %s", image)
+	}
+	return digest, nil
+}
+
+func (f *FakeRuntime) Close() error {
+	return nil
+}
@@ -18,7 +18,6 @@ import (
 	"github.com/dariubs/percent"
 	"github.com/dcnetio/dc/blockchain"
 	sym "github.com/dcnetio/gothreads-lib/crypto/symmetric"
-	"github.com/dcnetio/gothreads-lib/go-libp2p-pubsub-rpc/peer/mdns"
 	ipfslite "github.com/dcnetio/ipfs-lite"
 	gproto "github.com/gogo/protobuf/proto"
 	"github.com/ipfs/boxo/ipld/merkledag"
@@ -29,7 +28,9 @@ import (
 	ipld "github.com/ipfs/go-ipld-format"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
 	"github.com/multiformats/go-multiaddr"
 )
 
@@ -146,11 +147,12 @@ func DownloadFromIpfs(fcid, secret, savePath string, addrInfos []peer.AddrInfo,
 	if err != nil {
 		return err
 	}
-	//Enable mdns service for discovery within lan (local area network)
-	err = mdns.Start(ctx, h)
-	if err != nil {
+	//Enable mdns service for discovery within lan (local area network),closed with the host so that the daemon does not keep one per download
+	mdnsService := mdns.NewMdnsService(h, mdns.ServiceName, &mdnsNotifee{ctx: ctx, host: h})
+	if err = mdnsService.Start(); err != nil {
 		fmt.Println("mdns start error:", err)
 	}
+	defer mdnsService.Close()
 	c, _ := cid.Decode(fcid)
 	ioReader, err := lite.GetFile(ctx, c)
 	if err != nil {
@@ -170,6 +172,20 @@ func DownloadFromIpfs(fcid, secret, savePath string, addrInfos []peer.AddrInfo,
 
 }
 
+// mdnsNotifee connects to the peers found by mdns
+type mdnsNotifee struct {
+	ctx  context.Context
+	host host.Host
+}
+
+func (n *mdnsNotifee) HandlePeerFound(p peer.AddrInfo) {
+	ctx, cancel := context.WithTimeout(n.ctx, 10*time.Second)
+	defer cancel()
+	if err := n.host.Connect(ctx, p); err != nil {
+		log.Warnf("connect to peer %s found by mdns fail,err: %v", p.ID, err)
+	}
+}
+
 // DownloadFile download file
 func downloadFile(ctx context.Context, ioReader ufsio.ReadSeekCloser, savePath string, secret string, fileTransmit FileTransmit) error {
 	completeFlag := false
@@ -0,0 +1,297 @@
+package util
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var logFile *RotatingFile //file of the primary log core
+
+// Suffix time format of the rotated log files, e.g. log.20240102T150405.000
+const rotatedTimeFormat = "20060102T150405.000"
+
+// Interval at which a writer checks whether another process rotated the file,
+// until then its lines still go to the rotated file
+const replacedCheckInterval = time.Second
+
+// LogFileConfig is the format and rotation of the log file
+type LogFileConfig struct {
+	Json       bool
+	Level      string        //debug,info,warn or error,empty for info
+	MaxSize    int64         //bytes after which the file is rotated,0 disables rotation by size
+	MaxAge     time.Duration //age after which the file is rotated,0 disables rotation by age
+	MaxBackups int           //rotated files to keep,0 keeps all
+	Compress   bool          //gzip the rotated files
+	Labels     map[string]string
+}
+
+// RotatingFile is a log file that is renamed to <path>.<time> when it grows over MaxSize or gets older than MaxAge.
+// All dc commands and the daemon append to the same file, so a file rotated by another process is reopened
+// within replacedCheckInterval, and the rotation itself is done under the flock of <path>.lock.
+type RotatingFile struct {
+	mu        sync.Mutex
+	path      string
+	config    LogFileConfig
+	file      *os.File
+	openedAt  time.Time //start of the age of the current file
+	size      int64     //size of the file at the last check plus the bytes written since
+	checkedAt time.Time //last check whether the file was replaced
+}
+
+// OpenRotatingFile opens the log file at path for appending
+func OpenRotatingFile(path string, config LogFileConfig) (r *RotatingFile, err error) {
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	r = &RotatingFile{path: path, config: config}
+	err = r.open()
+	return
+}
+
+// open the file at path,its age starts at the last rotation,or at its last change if it was never rotated. r.mu must be held
+func (r *RotatingFile) open() (err error) {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	r.file = file
+	r.openedAt = time.Now()
+	r.checkedAt = r.openedAt
+	r.size = 0
+	info, serr := file.Stat()
+	if serr == nil {
+		r.size = info.Size()
+	}
+	backups := r.backups()
+	if len(backups) > 0 {
+		name := strings.TrimSuffix(strings.TrimPrefix(backups[len(backups)-1], r.path+"."), ".gz")
+		if t, perr := time.ParseInLocation(rotatedTimeFormat, name, time.Local); perr == nil {
+			r.openedAt = t
+		}
+	} else if serr == nil && info.Size() > 0 {
+		r.openedAt = info.ModTime()
+	}
+	return
+}
+
+// Whether the file at path is no longer the open file,because another process rotated it. r.mu must be held
+func (r *RotatingFile) replaced() bool {
+	pathInfo, err := os.Stat(r.path)
+	if err != nil {
+		return true
+	}
+	fileInfo, err := r.file.Stat()
+	return err == nil && !os.SameFile(pathInfo, fileInfo)
+}
+
+// Rotated files of the log, oldest first
+func (r *RotatingFile) backups() []string {
+	matches, _ := filepath.Glob(r.path + ".*")
+	backups := []string{}
+	for _, match := range matches {
+		name := strings.TrimSuffix(strings.TrimPrefix(match, r.path+"."), ".gz")
+		if _, err := time.ParseInLocation(rotatedTimeFormat, name, time.Local); err == nil {
+			backups = append(backups, match)
+		}
+	}
+	sort.Strings(backups)
+	return backups
+}
+
+func (r *RotatingFile) Write(p []byte) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		if err = r.open(); err != nil {
+			return
+		}
+	}
+	//reopen the file if another process rotated it,the size written by the other processes is picked up too
+	if time.Since(r.checkedAt) >= replacedCheckInterval {
+		r.checkedAt = time.Now()
+		if r.replaced() {
+			r.file.Close()
+			if err = r.open(); err != nil {
+				return
+			}
+		} else if info, serr := r.file.Stat(); serr == nil {
+			r.size = info.Size()
+		}
+	}
+	if r.size > 0 && r.rotateDue(len(p)) {
+		if rerr := r.rotate(len(p)); rerr != nil {
+			fmt.Fprintf(os.Stderr, "rotate log file %s fail,err: %v\n", r.path, rerr)
+		}
+	}
+	n, err = r.file.Write(p)
+	r.size += int64(n)
+	return
+}
+
+// Whether writing size bytes takes the file over MaxSize or it is older than MaxAge. r.mu must be held
+func (r *RotatingFile) rotateDue(size int) bool {
+	return (r.config.MaxSize > 0 && r.size+int64(size) > r.config.MaxSize) ||
+		(r.config.MaxAge > 0 && time.Since(r.openedAt) > r.config.MaxAge)
+}
+
+// Rename the current file,compress the older backups and remove the backups over MaxBackups. r.mu must be held.
+// The rotation holds the flock of <path>.lock,so that the dc commands and the daemon do not rotate the same file twice.
+func (r *RotatingFile) rotate(size int) (err error) {
+	lockFile, err := os.OpenFile(r.path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return
+	}
+	defer lockFile.Close() //closing it releases the flock
+	if err = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		return
+	}
+	//another process may have rotated the file while the lock was waited for
+	if r.replaced() {
+		r.file.Close()
+		if err = r.open(); err != nil || r.size == 0 || !r.rotateDue(size) {
+			return
+		}
+	}
+	r.file.Close()
+	backup := ""
+	for t := time.Now(); backup == ""; t = t.Add(time.Millisecond) { //do not overwrite a backup of the same millisecond
+		backup = r.path + "." + t.Format(rotatedTimeFormat)
+		if _, serr := os.Stat(backup); serr == nil {
+			backup = ""
+		} else if _, serr = os.Stat(backup + ".gz"); serr == nil {
+			backup = ""
+		}
+	}
+	if err = os.Rename(r.path, backup); err != nil {
+		r.open()
+		return
+	}
+	if err = r.open(); err != nil {
+		return
+	}
+	backups := r.backups()
+	if r.config.Compress {
+		//the other processes may append to the new backup until they notice the rotation,it is compressed by the next one
+		for _, old := range backups {
+			if old != backup && !strings.HasSuffix(old, ".gz") {
+				if cerr := compressFile(old); cerr != nil {
+					fmt.Fprintf(os.Stderr, "compress log file %s fail,err: %v\n", old, cerr)
+				}
+			}
+		}
+		backups = r.backups()
+	}
+	if r.config.MaxBackups > 0 {
+		for i := 0; i < len(backups)-r.config.MaxBackups; i++ {
+			os.Remove(backups[i])
+		}
+	}
+	return
+}
+
+// Compress the file to <file>.gz and remove it
+func compressFile(path string) (err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err = io.Copy(gz, src); err == nil {
+		err = gz.Close()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return
+	}
+	return os.Remove(path)
+}
+
+func (r *RotatingFile) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Sync()
+}
+
+// Close the file,a later write opens it again
+func (r *RotatingFile) Close() (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file != nil {
+		err = r.file.Close()
+		r.file = nil
+	}
+	return
+}
+
+// SetupLoggingConfig logs to file in text or json format,rotating it as configured
+func SetupLoggingConfig(file string, config LogFileConfig) error {
+	level := logging.LevelInfo
+	if config.Level != "" {
+		var err error
+		if level, err = logging.LevelFromString(config.Level); err != nil {
+			return err
+		}
+	}
+	c := logging.Config{
+		Format: logging.PlaintextOutput,
+		Level:  level,
+	}
+	if config.Json {
+		c.Format = logging.JSONOutput
+	}
+	//set the levels of the loggers,the output is replaced by the core of the rotating file
+	logging.SetupLogging(c)
+	if file == "" {
+		return nil
+	}
+	rf, err := OpenRotatingFile(file, config)
+	if err != nil {
+		return err
+	}
+	//the file of a previous setup is closed once the new core replaces it
+	previous := logFile
+	logFile = rf
+	defer func() {
+		if previous != nil {
+			previous.Close()
+		}
+	}()
+	encCfg := zap.NewProductionEncoderConfig()
+	encCfg.EncodeTime = zapcore.ISO8601TimeEncoder
+	var encoder zapcore.Encoder
+	if config.Json {
+		encoder = zapcore.NewJSONEncoder(encCfg)
+	} else {
+		encCfg.EncodeLevel = zapcore.CapitalLevelEncoder
+		encoder = zapcore.NewConsoleEncoder(encCfg)
+	}
+	core := zapcore.NewCore(encoder, rf, zap.NewAtomicLevelAt(zapcore.DebugLevel))
+	for k, v := range config.Labels {
+		core = core.With([]zap.Field{zap.String(k, v)})
+	}
+	logging.SetPrimaryCore(core)
+	return nil
+}
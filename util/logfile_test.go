@@ -0,0 +1,93 @@
+package util
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Lines of the log file and its backups
+func readLogLines(t *testing.T, path string) (lines int) {
+	t.Helper()
+	files, _ := filepath.Glob(path + "*")
+	for _, file := range files {
+		if strings.HasSuffix(file, ".lock") {
+			continue
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasSuffix(file, ".gz") {
+			gz, err := gzip.NewReader(bytes.NewReader(content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if content, err = io.ReadAll(gz); err != nil {
+				t.Fatal(err)
+			}
+		}
+		lines += bytes.Count(content, []byte("\n"))
+	}
+	return
+}
+
+func TestRotatingFile(t *testing.T) {
+	line := []byte(strings.Repeat("x", 19) + "\n")
+	tests := []struct {
+		name        string
+		config      LogFileConfig
+		writes      [][2]int //writer index and lines written by it
+		wantBackups int
+		wantGzip    int
+	}{
+		{name: "no rotation", config: LogFileConfig{MaxSize: 1000}, writes: [][2]int{{0, 10}, {1, 10}}},
+		{name: "rotate by size", config: LogFileConfig{MaxSize: 100}, writes: [][2]int{{0, 6}}, wantBackups: 1},
+		//the second writer has not noticed the rotation of the first,it must not rotate the new file again
+		{name: "rotated by the other writer", config: LogFileConfig{MaxSize: 100}, writes: [][2]int{{1, 4}, {0, 2}, {1, 2}}, wantBackups: 1},
+		{name: "keep max backups", config: LogFileConfig{MaxSize: 100, MaxBackups: 2}, writes: [][2]int{{0, 30}}, wantBackups: 2},
+		{name: "compress older backups", config: LogFileConfig{MaxSize: 100, Compress: true}, writes: [][2]int{{0, 16}}, wantBackups: 3, wantGzip: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "log")
+			writers := []*RotatingFile{}
+			for i := 0; i < 2; i++ {
+				r, err := OpenRotatingFile(path, tt.config)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer r.Close()
+				writers = append(writers, r)
+			}
+			total := 0
+			for _, write := range tt.writes {
+				for i := 0; i < write[1]; i++ {
+					if _, err := writers[write[0]].Write(line); err != nil {
+						t.Fatal(err)
+					}
+					total++
+				}
+			}
+			backups := writers[0].backups()
+			gzipped := 0
+			for _, backup := range backups {
+				if strings.HasSuffix(backup, ".gz") {
+					gzipped++
+				}
+			}
+			if len(backups) != tt.wantBackups || gzipped != tt.wantGzip {
+				t.Errorf("backups %v,want %d with %d gzipped", backups, tt.wantBackups, tt.wantGzip)
+			}
+			if tt.config.MaxBackups == 0 {
+				if lines := readLogLines(t, path); lines != total {
+					t.Errorf("%d lines in the log files,want %d", lines, total)
+				}
+			}
+		})
+	}
+}
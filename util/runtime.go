@@ -0,0 +1,183 @@
+package util
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+)
+
+// ContainerRuntime is the set of container engine operations used by dcmanager.
+// All container,volume and image handling goes through it, so that the start/stop/upgrade flows
+// can run against an in-memory runtime on machines without docker and sgx.
+type ContainerRuntime interface {
+	// ContainerList lists containers, all: include containers that are not running
+	ContainerList(ctx context.Context, all bool) ([]types.Container, error)
+	ContainerInspect(ctx context.Context, containerId string) (types.ContainerJSON, error)
+	// ContainerCreate creates a container with the given name and returns its id
+	ContainerCreate(ctx context.Context, containerName string, config *container.Config, hostConfig *container.HostConfig) (containerId string, err error)
+	ContainerStart(ctx context.Context, containerId string) error
+	// ContainerStop stops a container, waitTimeout is the seconds to wait before killing it,nil means engine default
+	ContainerStop(ctx context.Context, containerId string, waitTimeout *int) error
+	// ContainerRemove force removes a container
+	ContainerRemove(ctx context.Context, containerId string) error
+	ContainerLogs(ctx context.Context, containerId string, options types.ContainerLogsOptions) (io.ReadCloser, error)
+	VolumeList(ctx context.Context) ([]*volume.Volume, error)
+	VolumeCreate(ctx context.Context, volumeName string) (volume.Volume, error)
+	VolumeRemove(ctx context.Context, volumeName string) error
+	// ImagePull pulls an image, the returned progress stream must be read to the end and closed
+	ImagePull(ctx context.Context, image string) (io.ReadCloser, error)
+	// ImageLoad imports an image from a tar stream
+	ImageLoad(ctx context.Context, input io.Reader) error
+	// ImageManifestDigest resolves the manifest of an image in its registry without pulling it
+	ImageManifestDigest(ctx context.Context, image string) (digest string, err error)
+	Close() error
+}
+
+var gRuntime ContainerRuntime
+var gRuntimeLock sync.Mutex
+
+// GetContainerRuntime returns the container runtime shared by all container operations,
+// a docker backed runtime is created on first use if none has been set.
+func GetContainerRuntime() (ContainerRuntime, error) {
+	gRuntimeLock.Lock()
+	defer gRuntimeLock.Unlock()
+	if gRuntime != nil {
+		return gRuntime, nil
+	}
+	rt, err := NewDockerRuntime()
+	if err != nil {
+		return nil, err
+	}
+	gRuntime = rt
+	return gRuntime, nil
+}
+
+// SetContainerRuntime replaces the shared container runtime, e.g. with a FakeRuntime in tests
+func SetContainerRuntime(rt ContainerRuntime) {
+	gRuntimeLock.Lock()
+	defer gRuntimeLock.Unlock()
+	gRuntime = rt
+}
+
+// CloseContainerRuntime closes the shared container runtime, the next container operation creates a new one.
+// The daemon keeps one runtime for its whole life and closes it when it exits.
+func CloseContainerRuntime() (err error) {
+	gRuntimeLock.Lock()
+	defer gRuntimeLock.Unlock()
+	if gRuntime == nil {
+		return
+	}
+	err = gRuntime.Close()
+	gRuntime = nil
+	return
+}
+
+// FindContainerIdByName returns the id of the container with the given name, or "" if there is none
+func FindContainerIdByName(ctx context.Context, rt ContainerRuntime, containerName string, all bool) (containerId string, err error) {
+	containers, err := rt.ContainerList(ctx, all)
+	if err != nil {
+		return
+	}
+	for _, container := range containers {
+		for _, name := range container.Names {
+			if name == "/"+containerName {
+				containerId = container.ID
+				return
+			}
+		}
+	}
+	return
+}
+
+// dockerRuntime is the ContainerRuntime backed by the local docker daemon
+type dockerRuntime struct {
+	cli *client.Client
+}
+
+// NewDockerRuntime connects to the docker daemon configured by the environment
+func NewDockerRuntime() (ContainerRuntime, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+	return &dockerRuntime{cli: cli}, nil
+}
+
+func (d *dockerRuntime) ContainerList(ctx context.Context, all bool) ([]types.Container, error) {
+	return d.cli.ContainerList(ctx, types.ContainerListOptions{All: all})
+}
+
+func (d *dockerRuntime) ContainerInspect(ctx context.Context, containerId string) (types.ContainerJSON, error) {
+	return d.cli.ContainerInspect(ctx, containerId)
+}
+
+func (d *dockerRuntime) ContainerCreate(ctx context.Context, containerName string, config *container.Config, hostConfig *container.HostConfig) (string, error) {
+	resp, err := d.cli.ContainerCreate(ctx, config, hostConfig, nil, nil, containerName)
+	if err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+func (d *dockerRuntime) ContainerStart(ctx context.Context, containerId string) error {
+	return d.cli.ContainerStart(ctx, containerId, types.ContainerStartOptions{})
+}
+
+func (d *dockerRuntime) ContainerStop(ctx context.Context, containerId string, waitTimeout *int) error {
+	return d.cli.ContainerStop(ctx, containerId, container.StopOptions{Timeout: waitTimeout})
+}
+
+func (d *dockerRuntime) ContainerRemove(ctx context.Context, containerId string) error {
+	return d.cli.ContainerRemove(ctx, containerId, types.ContainerRemoveOptions{Force: true})
+}
+
+func (d *dockerRuntime) ContainerLogs(ctx context.Context, containerId string, options types.ContainerLogsOptions) (io.ReadCloser, error) {
+	return d.cli.ContainerLogs(ctx, containerId, options)
+}
+
+func (d *dockerRuntime) VolumeList(ctx context.Context) ([]*volume.Volume, error) {
+	resp, err := d.cli.VolumeList(ctx, volume.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Volumes, nil
+}
+
+func (d *dockerRuntime) VolumeCreate(ctx context.Context, volumeName string) (volume.Volume, error) {
+	return d.cli.VolumeCreate(ctx, volume.CreateOptions{Name: volumeName})
+}
+
+func (d *dockerRuntime) VolumeRemove(ctx context.Context, volumeName string) error {
+	return d.cli.VolumeRemove(ctx, volumeName, true)
+}
+
+func (d *dockerRuntime) ImagePull(ctx context.Context, image string) (io.ReadCloser, error) {
+	return d.cli.ImagePull(ctx, image, types.ImagePullOptions{})
+}
+
+func (d *dockerRuntime) ImageLoad(ctx context.Context, input io.Reader) error {
+	resp, err := d.cli.ImageLoad(ctx, input, true)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
+
+func (d *dockerRuntime) ImageManifestDigest(ctx context.Context, image string) (string, error) {
+	inspect, err := d.cli.DistributionInspect(ctx, image, "")
+	if err != nil {
+		return "", err
+	}
+	return inspect.Descriptor.Digest.String(), nil
+}
+
+func (d *dockerRuntime) Close() error {
+	return d.cli.Close()
+}
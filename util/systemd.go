@@ -0,0 +1,94 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// State of a systemd unit, from systemctl show
+type SystemdUnitStatus struct {
+	Unit        string `json:"unit" yaml:"unit"`
+	ActiveState string `json:"activeState" yaml:"activeState"` //active,inactive,failed,activating,...
+	SubState    string `json:"subState" yaml:"subState"`       //running,dead,auto-restart,...
+	StatusText  string `json:"statusText,omitempty" yaml:"statusText,omitempty"`
+	MainPid     int    `json:"mainPid,omitempty" yaml:"mainPid,omitempty"`
+}
+
+// RunBySystemd reports whether the process is started by a systemd unit
+func RunBySystemd() bool {
+	return os.Getenv("INVOCATION_ID") != "" || os.Getenv("NOTIFY_SOCKET") != ""
+}
+
+// SdNotify sends a state such as READY=1, WATCHDOG=1 or STATUS=... to systemd through $NOTIFY_SOCKET.
+// sent is false when the process is not run by a unit of Type=notify.
+func SdNotify(state string) (sent bool, err error) {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return
+	}
+	addr := &net.UnixAddr{Name: socketPath, Net: "unixgram"}
+	if strings.HasPrefix(socketPath, "@") { //abstract socket
+		addr.Name = "\x00" + socketPath[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(state)); err != nil {
+		return
+	}
+	return true, nil
+}
+
+// SdWatchdogTimeout returns the WatchdogSec of the unit, the process is killed if it does not send WATCHDOG=1 within it. 0 if the watchdog is disabled.
+func SdWatchdogTimeout() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) { //meant for another process
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// GetSystemdUnitStatus queries the state of the unit with systemctl, returns an error if systemd or the unit is not available
+func GetSystemdUnitStatus(unit string) (status *SystemdUnitStatus, err error) {
+	if _, err = exec.LookPath("systemctl"); err != nil {
+		return
+	}
+	output, err := exec.Command("systemctl", "show", unit, "--property=LoadState,ActiveState,SubState,StatusText,MainPID").Output()
+	if err != nil {
+		return
+	}
+	status = &SystemdUnitStatus{Unit: unit}
+	loadState := ""
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		switch key {
+		case "LoadState":
+			loadState = value
+		case "ActiveState":
+			status.ActiveState = value
+		case "SubState":
+			status.SubState = value
+		case "StatusText":
+			status.StatusText = value
+		case "MainPID":
+			status.MainPid, _ = strconv.Atoi(value)
+		}
+	}
+	if loadState != "loaded" {
+		return nil, fmt.Errorf("unit %s is %s", unit, loadState)
+	}
+	return
+}
@@ -11,17 +11,14 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
-	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
 
 	"github.com/ChainSafe/go-schnorrkel"
 	"github.com/cosmos/go-bip39"
-	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/volume"
-	"github.com/docker/docker/client"
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/klauspost/cpuid"
 	"github.com/libp2p/go-libp2p/core/crypto"
@@ -30,7 +27,12 @@ import (
 var log = logging.Logger("dcmanager")
 
 func HttpGet(url string, args ...string) ([]byte, error) {
-	client := http.Client{Timeout: 10 * time.Second}
+	return HttpGetWithTimeout(url, 10*time.Second, args...)
+}
+
+// HttpGetWithTimeout is HttpGet for requests that take longer than the default 10 seconds
+func HttpGetWithTimeout(url string, timeout time.Duration, args ...string) ([]byte, error) {
+	client := http.Client{Timeout: timeout}
 	if len(args) > 0 {
 		url += "?" + strings.Join(args, "&")
 	}
@@ -55,16 +57,19 @@ func HttpGet(url string, args ...string) ([]byte, error) {
 	return body, nil
 }
 
+// Transport of the requests without certificate check,shared so that its idle connections are reused instead of piling up in the daemon
+var insecureTransport = &http.Transport{
+	TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	IdleConnTimeout: time.Minute,
+}
+
 func HttpGetWithoutCheckCert(url string, args ...string) ([]byte, error) {
 	//	client := http.Client{Timeout: time.Second}
 	if len(args) > 0 {
 		url += "?" + strings.Join(args, "&")
 	}
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
 	client := &http.Client{
-		Transport: tr,
+		Transport: insecureTransport,
 		Timeout:   time.Second}
 	//request with out check cert
 	req, err := http.NewRequest("GET", url, nil)
@@ -144,20 +149,7 @@ func GetRandomPrivKey() (crypto.PrivKey, error) {
 
 // SetupDefaultLoggingConfig sets up a standard logging configuration.
 func SetupDefaultLoggingConfig(file string) error {
-	c := logging.Config{
-		Format: logging.ColorizedOutput,
-		Stderr: false,
-		Level:  logging.LevelInfo,
-	}
-	if file != "" {
-		if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
-			return err
-		}
-		c.File = file
-	}
-	os.Chmod(file, 0777)
-	logging.SetupLogging(c)
-	return nil
+	return SetupLoggingConfig(file, LogFileConfig{})
 }
 
 func Sha256sum(filepath string) (checksum string, err error) {
@@ -178,22 +170,20 @@ func Sha256sum(filepath string) (checksum string, err error) {
 
 // create volume
 func CreateVolume(ctx context.Context, volumeName string) (v *volume.Volume, err error) {
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+	cli, err := GetContainerRuntime()
 	if err != nil {
 		log.Fatalf("create docker client fail,err:%v", err)
 	}
-	volumeList, err := cli.VolumeList(ctx, volume.ListOptions{})
+	volumes, err := cli.VolumeList(ctx)
 	if err != nil {
 		log.Fatalf("list docker volume fail,err:%v", err)
 	}
-	for _, v = range volumeList.Volumes {
+	for _, v = range volumes {
 		if v.Name == volumeName {
 			return
 		}
 	}
-	newVolume, err := cli.VolumeCreate(ctx, volume.CreateOptions{
-		Name: volumeName,
-	})
+	newVolume, err := cli.VolumeCreate(ctx, volumeName)
 	v = &newVolume
 	if err != nil {
 		log.Fatalf("create docker volume fail,err:%v", err)
@@ -205,12 +195,12 @@ func CreateVolume(ctx context.Context, volumeName string) (v *volume.Volume, err
 
 // start container removeOldFlag: true  if exist same name container with different image,remove the old container
 func StartContainer(ctx context.Context, containerName string, removeOldFlag bool, config *container.Config, hostConfig *container.HostConfig) (err error) {
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+	clog := log.With("container", containerName) //structured field of the json log format
+	cli, err := GetContainerRuntime()
 	if err != nil {
 		return
 	}
-	defer cli.Close()
-	containers, err := cli.ContainerList(context.Background(), types.ContainerListOptions{All: true})
+	containers, err := cli.ContainerList(ctx, true)
 	if err != nil {
 		return
 	}
@@ -229,8 +219,8 @@ func StartContainer(ctx context.Context, containerName string, removeOldFlag boo
 	}
 	if !createdFlag { //need to create
 		fmt.Printf("creating %s container ...\n", containerName)
-		log.Infof("creating %s container ...", containerName)
-		resp, cerr := cli.ContainerCreate(ctx, config, hostConfig, nil, nil, containerName)
+		clog.Infof("creating %s container ...", containerName)
+		newId, cerr := cli.ContainerCreate(ctx, containerName, config, hostConfig)
 		if cerr != nil {
 
 			conflictMsg := fmt.Sprintf("Conflict. The container name \"/%s\" is already in use by container", containerName)
@@ -238,21 +228,21 @@ func StartContainer(ctx context.Context, containerName string, removeOldFlag boo
 			if strings.Contains(cerr.Error(), conflictMsg) && removeOldFlag {
 
 				fmt.Printf("container %s already exists, need remove \n", containerName)
-				log.Infof("container %s already exists, remove  it", containerName)
+				clog.Infof("container %s already exists, remove  it", containerName)
 				fmt.Printf("stopping %s container ...\n", containerName)
-				log.Infof("stopping %s container ...", containerName)
-				err = cli.ContainerStop(ctx, containerName, container.StopOptions{})
+				clog.Infof("stopping %s container ...", containerName)
+				err = cli.ContainerStop(ctx, containerName, nil)
 				if err != nil {
 					return
 				}
 				fmt.Printf("removing %s container ...\n", containerName)
-				log.Infof("removing %s container ...", containerName)
-				if err = cli.ContainerRemove(ctx, containerName, types.ContainerRemoveOptions{Force: true}); err != nil {
+				clog.Infof("removing %s container ...", containerName)
+				if err = cli.ContainerRemove(ctx, containerName); err != nil {
 					return
 				}
 				fmt.Printf("creating %s container ...\n", containerName)
-				log.Infof("creating %s container ...", containerName)
-				resp, err = cli.ContainerCreate(ctx, config, hostConfig, nil, nil, containerName)
+				clog.Infof("creating %s container ...", containerName)
+				newId, err = cli.ContainerCreate(ctx, containerName, config, hostConfig)
 				if err != nil {
 					return
 				}
@@ -262,26 +252,26 @@ func StartContainer(ctx context.Context, containerName string, removeOldFlag boo
 				return
 			}
 		}
-		containerId = resp.ID
+		containerId = newId
 	}
 
 	execResp, err := cli.ContainerInspect(ctx, containerId)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "inspect %s container fail,err: %v\r\n", containerName, err)
-		log.Infof("inspect %s container fail,err: %v", containerName, err)
+		clog.Infof("inspect %s container fail,err: %v", containerName, err)
 		return
 
 	}
 	if !execResp.State.Running { // The service is not started
 		fmt.Printf("starting %s  ...\n", containerName)
-		log.Infof("starting %s  ...\n", containerName)
-		if err := cli.ContainerStart(ctx, containerId, types.ContainerStartOptions{}); err != nil {
+		clog.Infof("starting %s  ...\n", containerName)
+		if err := cli.ContainerStart(ctx, containerId); err != nil {
 			fmt.Fprintf(os.Stderr, "start %s fail,err: %v\r\n", containerName, err)
-			log.Infof("start %s fail,err: %v", containerName, err)
+			clog.Infof("start %s fail,err: %v", containerName, err)
 			return err
 		}
 		fmt.Printf("start %s success\r\n", containerName)
-		log.Infof("start %s success", containerName)
+		clog.Infof("start %s success", containerName)
 	} else {
 		fmt.Printf("%s is running\r\n", containerName)
 	}
@@ -290,80 +280,62 @@ func StartContainer(ctx context.Context, containerName string, removeOldFlag boo
 
 // stop container
 func StopContainer(ctx context.Context, containerName string, waitTimeout int) (err error) {
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+	clog := log.With("container", containerName)
+	cli, err := GetContainerRuntime()
 	if err != nil {
 		return
 	}
-	defer cli.Close()
-	containers, err := cli.ContainerList(context.Background(), types.ContainerListOptions{})
+	containerId, err := FindContainerIdByName(ctx, cli, containerName, false)
 	if err != nil {
 		return
 	}
-	containerId := ""
-	for _, container := range containers {
-		for _, name := range container.Names {
-			if name == "/"+containerName {
-				containerId = container.ID
-				break
-			}
-		}
-	}
 	if containerId != "" {
 		fmt.Printf("stopping %s  ...\r\n", containerName)
-		log.Infof("stopping %s  ...", containerName)
-		if err = cli.ContainerStop(ctx, containerId, container.StopOptions{Timeout: &waitTimeout}); err != nil {
+		clog.Infof("stopping %s  ...", containerName)
+		if err = cli.ContainerStop(ctx, containerId, &waitTimeout); err != nil {
 			fmt.Fprintf(os.Stderr, "stop %s  fail,err: %v\r\n", containerName, err)
-			log.Infof("stop %s  fail,err: %v", containerName, err)
+			clog.Infof("stop %s  fail,err: %v", containerName, err)
 			return
 		}
 	} else {
 		fmt.Printf("%s  is not running\r\n", containerName)
-		log.Infof("no need stop, %s  is not running", containerName)
+		clog.Infof("no need stop, %s  is not running", containerName)
 	}
 	return
 }
 
 func RemoveContainer(ctx context.Context, containerName string) (err error) {
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+	clog := log.With("container", containerName)
+	cli, err := GetContainerRuntime()
 	if err != nil {
 		return
 	}
-	defer cli.Close()
-	containers, err := cli.ContainerList(context.Background(), types.ContainerListOptions{All: true})
+	containerId, err := FindContainerIdByName(ctx, cli, containerName, true)
 	if err != nil {
 		return
 	}
-	containerId := ""
-	for _, container := range containers {
-		for _, name := range container.Names {
-			if name == "/"+containerName {
-				containerId = container.ID
-				break
-			}
-		}
-	}
 	if containerId != "" {
 		execResp, ierr := cli.ContainerInspect(ctx, containerId)
 		if ierr != nil {
 			fmt.Fprintf(os.Stderr, "inspect %s container fail,err: %v\r\n", containerName, ierr)
-			log.Infof("inspect %s container fail,err: %v", containerName, ierr)
+			clog.Infof("inspect %s container fail,err: %v", containerName, ierr)
 			return ierr
 
 		}
 		if execResp.State.Running { // The service is still started and needs to be stopped first.
 			fmt.Printf("stopping %s  ...\r\n", containerName)
-			log.Infof("stopping %s  ...", containerName)
-			if err = cli.ContainerStop(ctx, containerId, container.StopOptions{}); err != nil {
+			clog.Infof("stopping %s  ...", containerName)
+			if err = cli.ContainerStop(ctx, containerId, nil); err != nil {
 				fmt.Fprintf(os.Stderr, "stop %s  fail,err: %v\r\n", containerName, err)
-				log.Infof("stop %s  fail,err: %v", containerName, err)
+				clog.Infof("stop %s  fail,err: %v", containerName, err)
 				return
 			}
 		}
 		fmt.Printf("removing container %s  ...\r\n", containerName)
-		log.Infof("removing container %s  ...", containerName)
-		if err = cli.ContainerRemove(ctx, containerId, types.ContainerRemoveOptions{Force: true}); err != nil {
+		clog.Infof("removing container %s  ...", containerName)
+		if err = cli.ContainerRemove(ctx, containerId); err != nil {
 			fmt.Fprintf(os.Stderr, "remove container %s  fail,err: %v\r\n", containerName, err)
-			log.Infof("remove container %s  fail,err: %v", containerName, err)
+			clog.Infof("remove container %s  fail,err: %v", containerName, err)
 			return err
 		}
 	}
@@ -375,17 +347,16 @@ func RemoveContainer(ctx context.Context, containerName string) (err error) {
 // RemoveVolume removes a volume
 func RemoveVolume(ctx context.Context, volumeName string) (err error) {
 	// Determine whether the volume exists
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+	cli, err := GetContainerRuntime()
 	if err != nil {
 		return
 	}
-	defer cli.Close()
-	volumes, err := cli.VolumeList(context.Background(), volume.ListOptions{})
+	volumes, err := cli.VolumeList(ctx)
 	if err != nil {
 		return
 	}
 	volumeId := ""
-	for _, volume := range volumes.Volumes {
+	for _, volume := range volumes {
 		if volume.Name == volumeName {
 			volumeId = volume.Name
 			break
@@ -394,7 +365,7 @@ func RemoveVolume(ctx context.Context, volumeName string) (err error) {
 	if volumeId != "" {
 		fmt.Printf("removing volume %s  ...\r\n", volumeName)
 		log.Infof("removing volume %s  ...", volumeName)
-		if err = cli.VolumeRemove(ctx, volumeId, true); err != nil {
+		if err = cli.VolumeRemove(ctx, volumeId); err != nil {
 			fmt.Fprintf(os.Stderr, "remove volume %s  fail,err: %v\r\n", volumeName, err)
 			log.Infof("remove volume %s  fail,err: %v", volumeName, err)
 			return err
@@ -0,0 +1,177 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// Create a container in the fake runtime,running if running is set
+func addFakeContainer(t *testing.T, fake *FakeRuntime, name string, image string, running bool) string {
+	t.Helper()
+	ctx := context.Background()
+	id, err := fake.ContainerCreate(ctx, name, &container.Config{Image: image}, &container.HostConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if running {
+		if err = fake.ContainerStart(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return id
+}
+
+// Use a fake runtime as the shared runtime for the test
+func useFakeRuntime(t *testing.T) *FakeRuntime {
+	t.Helper()
+	fake := NewFakeRuntime()
+	SetContainerRuntime(fake)
+	t.Cleanup(func() { SetContainerRuntime(nil) })
+	return fake
+}
+
+func TestFindContainerIdByName(t *testing.T) {
+	fake := NewFakeRuntime()
+	runningId := addFakeContainer(t, fake, "dcstorage", "dcnetio/dcstorage:1.0.0", true)
+	stoppedId := addFakeContainer(t, fake, "dcchain", "dcnetio/dcchain:1.0.0", false)
+	addFakeContainer(t, fake, "dcstorage_a", "dcnetio/dcstorage:1.0.0", true)
+	tests := []struct {
+		name          string
+		containerName string
+		all           bool
+		want          string
+		err           error
+	}{
+		{name: "running", containerName: "dcstorage", want: runningId},
+		{name: "stopped excluded", containerName: "dcchain", want: ""},
+		{name: "stopped with all", containerName: "dcchain", all: true, want: stoppedId},
+		{name: "no prefix match", containerName: "dcstor", all: true, want: ""},
+		{name: "not created", containerName: "dcpccs", all: true, want: ""},
+		{name: "list error", containerName: "dcstorage", err: errors.New("docker is down")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake.Errs["ContainerList"] = tt.err
+			id, err := FindContainerIdByName(context.Background(), fake, tt.containerName, tt.all)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v,want %v", err, tt.err)
+			}
+			if id != tt.want {
+				t.Errorf("id = %q,want %q", id, tt.want)
+			}
+		})
+	}
+}
+
+func TestStartContainer(t *testing.T) {
+	const image = "dcnetio/dcstorage:1.1.0"
+	tests := []struct {
+		name          string
+		existingImage string //image of an existing dcstorage container,empty for none
+		running       bool
+		removeOld     bool
+		errs          map[string]error
+		wantErr       bool
+		wantImage     string //image of the dcstorage container afterwards
+		wantCreates   int
+		wantRemoves   int
+	}{
+		{name: "create and start", wantImage: image, wantCreates: 1},
+		{name: "start stopped container", existingImage: image, wantImage: image},
+		{name: "already running", existingImage: image, running: true, wantImage: image},
+		{name: "replace other image", existingImage: "dcnetio/dcstorage:1.0.0", running: true, removeOld: true, wantImage: image, wantCreates: 2, wantRemoves: 1},
+		{name: "other image without removeOld", existingImage: "dcnetio/dcstorage:1.0.0", wantErr: true, wantImage: "dcnetio/dcstorage:1.0.0", wantCreates: 1},
+		{name: "start error", errs: map[string]error{"ContainerStart": errors.New("no space left")}, wantErr: true, wantImage: image, wantCreates: 1},
+		{name: "create error", errs: map[string]error{"ContainerCreate": errors.New("no such image")}, wantErr: true, wantCreates: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := useFakeRuntime(t)
+			if tt.existingImage != "" {
+				addFakeContainer(t, fake, "dcstorage", tt.existingImage, tt.running)
+			}
+			fake.Calls = nil
+			for method, err := range tt.errs {
+				fake.Errs[method] = err
+			}
+			err := StartContainer(context.Background(), "dcstorage", tt.removeOld, &container.Config{Image: image}, &container.HostConfig{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v,wantErr %v", err, tt.wantErr)
+			}
+			creates, removes := 0, 0
+			for _, call := range fake.Calls {
+				switch call {
+				case "ContainerCreate dcstorage":
+					creates++
+				case "ContainerRemove dcstorage":
+					removes++
+				}
+			}
+			if creates != tt.wantCreates || removes != tt.wantRemoves {
+				t.Errorf("creates %d,removes %d,want %d,%d,calls: %v", creates, removes, tt.wantCreates, tt.wantRemoves, fake.Calls)
+			}
+			c := fake.Container("dcstorage")
+			if tt.wantImage == "" {
+				if c != nil {
+					t.Errorf("container %s was created", c.Config.Image)
+				}
+				return
+			}
+			if c == nil {
+				t.Fatal("container is not created")
+			}
+			if c.Config.Image != tt.wantImage {
+				t.Errorf("image = %s,want %s", c.Config.Image, tt.wantImage)
+			}
+			if !tt.wantErr && !c.Running {
+				t.Error("container is not running")
+			}
+		})
+	}
+}
+
+func TestStopContainer(t *testing.T) {
+	tests := []struct {
+		name      string
+		exists    bool
+		running   bool
+		stopErr   error
+		wantErr   bool
+		wantStops int
+	}{
+		{name: "running", exists: true, running: true, wantStops: 1},
+		{name: "stopped", exists: true},
+		{name: "not created"},
+		{name: "stop error", exists: true, running: true, stopErr: errors.New("timeout"), wantErr: true, wantStops: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := useFakeRuntime(t)
+			id := ""
+			if tt.exists {
+				id = addFakeContainer(t, fake, "dcchain", "dcnetio/dcchain:1.0.0", tt.running)
+			}
+			fake.Calls = nil
+			fake.Errs["ContainerStop"] = tt.stopErr
+			err := StopContainer(context.Background(), "dcchain", 10)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v,wantErr %v", err, tt.wantErr)
+			}
+			stops := 0
+			for _, call := range fake.Calls {
+				if call == "ContainerStop "+id {
+					stops++
+				}
+			}
+			if stops != tt.wantStops {
+				t.Errorf("stops = %d,want %d,calls: %v", stops, tt.wantStops, fake.Calls)
+			}
+			if c := fake.Container("dcchain"); c != nil && c.Running && !tt.wantErr {
+				t.Error("container is still running")
+			}
+		})
+	}
+}